@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Triggers of audited runs: the cron schedule, RUN_NOW, the scheduler starting
+// (@startup jobs), or RUN_MISSED catching up a run missed during a downtime.
+const (
+	auditTriggerScheduled = "scheduled"
+	auditTriggerManual    = "manual"
+	auditTriggerStartup   = "startup"
+	auditTriggerCatchUp   = "catch_up"
+)
+
+// auditEntry is one job execution in the audit log. The field order is part of the
+// documented format: the hash of a line covers everything before its "hash" field.
+type auditEntry struct {
+	Seq           int64     `json:"seq"`
+	Time          time.Time `json:"time"`
+	Trigger       string    `json:"trigger"`
+	Job           string    `json:"job"`
+	Type          string    `json:"type"`
+	Target        string    `json:"target"`
+	CorrelationID string    `json:"correlation_id"`
+	StartedAt     time.Time `json:"started_at"`
+	FinishedAt    time.Time `json:"finished_at"`
+	Outcome       string    `json:"outcome"`
+	Error         string    `json:"error,omitempty"`
+	PrevHash      string    `json:"prev_hash"`
+}
+
+// auditLog appends every job execution to AUDIT_LOG_FILE as a hash-chained JSON line,
+// so that edited, removed or reordered entries can be detected. It is never pruned.
+// A nil *auditLog is valid and records nothing.
+type auditLog struct {
+	path     string
+	logger   *slog.Logger
+	mu       sync.Mutex
+	seq      int64
+	lastHash string
+}
+
+func newAuditLogFromEnv(logger *slog.Logger) *auditLog {
+	path := os.Getenv("AUDIT_LOG_FILE")
+	if path == "" {
+		return nil
+	}
+	a := &auditLog{path: path, logger: logger}
+	if err := a.resume(); err != nil {
+		logger.Error("Failed to read audit log, refusing to start a broken hash chain", "path", path, "error", err)
+		os.Exit(1)
+	}
+	logger.Info("Audit log enabled", "path", path, "seq", a.seq)
+	return a
+}
+
+// resume continues the hash chain from the last entry of an existing audit log.
+func (a *auditLog) resume() error {
+	f, err := os.Open(a.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var last []byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if last == nil {
+		return nil
+	}
+	var entry struct {
+		Seq  int64  `json:"seq"`
+		Hash string `json:"hash"`
+	}
+	if err := json.Unmarshal(last, &entry); err != nil {
+		return err
+	}
+	if entry.Hash == "" {
+		return errors.New("last entry has no hash")
+	}
+	a.seq, a.lastHash = entry.Seq, entry.Hash
+	return nil
+}
+
+// Record appends the execution described by rec, started by trigger.
+func (a *auditLog) Record(config Config, trigger string, rec runRecord) {
+	if a == nil {
+		return
+	}
+	outcome := "success"
+	if !rec.Success {
+		outcome = "failure"
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entry := auditEntry{
+		Seq:           a.seq + 1,
+		Time:          time.Now().UTC(),
+		Trigger:       trigger,
+		Job:           config.Name,
+		Type:          config.JobType,
+		Target:        auditTarget(config),
+		CorrelationID: rec.CorrelationID,
+		StartedAt:     rec.StartedAt.UTC(),
+		FinishedAt:    rec.FinishedAt.UTC(),
+		Outcome:       outcome,
+		Error:         rec.Error,
+		PrevHash:      a.lastHash,
+	}
+	body, err := json.Marshal(entry)
+	if err != nil {
+		a.logger.Error("Failed to encode audit entry", "job_name", config.Name, "error", err)
+		return
+	}
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	line := append(body[:len(body)-1], `,"hash":"`+hash+`"}`+"\n"...)
+
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		a.logger.Error("Failed to open audit log", "path", a.path, "error", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(line); err != nil {
+		a.logger.Error("Failed to write audit entry", "path", a.path, "error", err)
+		return
+	}
+	a.seq, a.lastHash = entry.Seq, hash
+}
+
+// auditTarget describes what a job executes, with credentials and the
+// SHELL_REDACT_PATTERNS matches masked.
+func auditTarget(config Config) string {
+	switch config.JobType {
+	case "http":
+		return config.HTTPMethod + " " + redactURLs(config.TargetURLs)
+	case "cert_expiry":
+		return config.CertHost
+	case "docker_run":
+		return redact(quoteArgs(dockerRunArgs(config, true)), config.ShellRedactPatterns)
+	}
+	if len(config.ShellTargets) > 0 {
+		targets := make([]string, len(config.ShellTargets))
+		for i, t := range config.ShellTargets {
+			targets[i] = t.Container + ": " + t.Command
+		}
+		return redact(strings.Join(targets, ";; "), config.ShellRedactPatterns)
+	}
+	target := config.ShellCommand
+	if config.ShellTargetContainer != "" {
+		target = config.ShellTargetContainer + ": " + target
+	}
+	return redact(target, config.ShellRedactPatterns)
+}
+
+// redactURLs is redactURL for the URLs of a fan-out job, separated by commas.
+func redactURLs(urls []string) string {
+	redacted := make([]string, len(urls))
+	for i, raw := range urls {
+		redacted[i] = redactURL(raw)
+	}
+	return strings.Join(redacted, ",")
+}
+
+// redactURL masks the password and query parameter values of a URL.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "***"
+	}
+	query := u.Query()
+	u.RawQuery = ""
+	s := u.Redacted()
+	if len(query) > 0 {
+		keys := make([]string, 0, len(query))
+		for key := range query {
+			keys = append(keys, url.QueryEscape(key)+"=***")
+		}
+		sort.Strings(keys)
+		s += "?" + strings.Join(keys, "&")
+	}
+	return s
+}
+
+// redactURLError applies redactURL to the URL an http.Client error quotes, and with it
+// any credentials in its query, and returns err.
+func redactURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = redactURL(urlErr.URL)
+	}
+	return err
+}
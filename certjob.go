@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// certDialTimeout bounds the TCP connect and TLS handshake of a cert_expiry job.
+const certDialTimeout = 10 * time.Second
+
+// checkCertExpiry connects to config.CertHost, reads the certificate the server
+// presents for the host name (SNI) and fails if it expires within CertWarnDays or
+// does not verify.
+func checkCertExpiry(ctx context.Context, log *slog.Logger, config Config) error {
+	log.Info("Executing job", "target", config.CertHost)
+	host, _, err := net.SplitHostPort(config.CertHost)
+	if err != nil {
+		return err
+	}
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: certDialTimeout},
+		// Verification is done below, after the expiry has been read, so that an
+		// expired certificate is still reported with its expiry date.
+		Config: &tls.Config{ServerName: host, InsecureSkipVerify: true},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", config.CertHost)
+	if err != nil {
+		log.Error("TLS connection failed", "error", err)
+		return err
+	}
+	defer conn.Close()
+
+	state := conn.(*tls.Conn).ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		log.Error("Server presented no certificate")
+		return fmt.Errorf("%s presented no certificate", config.CertHost)
+	}
+	leaf := state.PeerCertificates[0]
+	remaining := time.Until(leaf.NotAfter)
+	daysRemaining := int(remaining.Hours() / 24)
+	fields := []interface{}{"subject", leaf.Subject.CommonName, "issuer", leaf.Issuer.CommonName, "not_after", leaf.NotAfter, "days_remaining", daysRemaining}
+
+	if remaining < time.Duration(config.CertWarnDays)*24*time.Hour {
+		log.Error("Certificate expires within the warning period", append(fields, "warn_days", config.CertWarnDays)...)
+		if remaining <= 0 {
+			return fmt.Errorf("certificate for %s expired on %s", host, leaf.NotAfter.Format(time.RFC3339))
+		}
+		return fmt.Errorf("certificate for %s expires in %d days, on %s", host, daysRemaining, leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Intermediates: intermediates}); err != nil {
+		log.Error("Certificate verification failed", append(fields, "error", err)...)
+		return fmt.Errorf("certificate verification failed: %w", err)
+	}
+
+	log.Info("Job completed successfully", fields...)
+	return nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// semaphore is a counting semaphore. A nil semaphore never blocks.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+// acquire blocks until a slot is free or ctx is done, logging when it has to wait.
+// what names the slot in log messages. The returned function releases the slot.
+func (s semaphore) acquire(ctx context.Context, log *slog.Logger, what string) (func(), error) {
+	if s == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case s <- struct{}{}:
+		return func() { <-s }, nil
+	default:
+	}
+
+	log.Info("Waiting for a free "+what+" slot", "limit", cap(s))
+	start := time.Now()
+	select {
+	case s <- struct{}{}:
+		log.Info("Acquired "+what+" slot", "waited", time.Since(start).String())
+		return func() { <-s }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// priorityDispatchDelay is how long a prioritized job pool collects the runs that
+// fire together before it starts the first of them in priority order.
+const priorityDispatchDelay = 50 * time.Millisecond
+
+// jobPool limits the runs in progress across all jobs (MAX_CONCURRENT_JOBS, or one in
+// SERIAL_MODE); a limit of 0 means no limit. Waiting runs start highest CRON_PRIORITY
+// first, then in the order they arrived. While any job has a priority and there is a
+// limit, runs are collected for priorityDispatchDelay before they are started, so
+// that jobs firing at the same time start in priority order whichever goroutine
+// happens to run first.
+type jobPool struct {
+	limit int
+
+	mu          sync.Mutex
+	running     int
+	waiting     []*poolTask
+	seq         uint64
+	prioritized bool
+	pending     bool // a delayed dispatch is scheduled
+}
+
+// poolTask is a run waiting in a jobPool.
+type poolTask struct {
+	priority int
+	seq      uint64
+	log      *slog.Logger
+	what     string
+	blocked  bool // logged as waiting for a slot
+	granted  chan struct{}
+}
+
+func newJobPool(limit int) *jobPool {
+	return &jobPool{limit: limit}
+}
+
+// setPriorities enables priority ordering if any of configs has a CRON_PRIORITY and
+// the pool has a limit; without one, every run starts right away and there is
+// nothing to order.
+func (p *jobPool) setPriorities(configs []Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prioritized = false
+	if p.limit == 0 {
+		return
+	}
+	for _, config := range configs {
+		if config.Priority != 0 {
+			p.prioritized = true
+		}
+	}
+}
+
+// acquire blocks until the run may start or ctx is done, logging when it has to wait
+// for a slot. what names the slot in log messages. The returned function releases it.
+func (p *jobPool) acquire(ctx context.Context, log *slog.Logger, what string, priority int) (func(), error) {
+	p.mu.Lock()
+	if !p.prioritized && len(p.waiting) == 0 && p.hasRoom() {
+		p.running++
+		p.mu.Unlock()
+		return p.release, nil
+	}
+	p.seq++
+	task := &poolTask{priority: priority, seq: p.seq, log: log, what: what, granted: make(chan struct{})}
+	p.waiting = append(p.waiting, task)
+	if p.prioritized && !p.pending {
+		p.pending = true
+		time.AfterFunc(priorityDispatchDelay, func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.pending = false
+			p.dispatch()
+		})
+	}
+	p.dispatch()
+	p.mu.Unlock()
+
+	start := time.Now()
+	select {
+	case <-task.granted:
+		if task.blocked {
+			log.Info("Acquired "+what+" slot", "waited", time.Since(start).String())
+		}
+		return p.release, nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for i, t := range p.waiting {
+			if t == task {
+				p.waiting = append(p.waiting[:i], p.waiting[i+1:]...)
+				return nil, ctx.Err()
+			}
+		}
+		// The slot was granted while ctx ended: hand it on.
+		p.running--
+		p.dispatch()
+		return nil, ctx.Err()
+	}
+}
+
+func (p *jobPool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running--
+	p.dispatch()
+}
+
+func (p *jobPool) hasRoom() bool {
+	return p.limit == 0 || p.running < p.limit
+}
+
+// dispatch starts waiting runs, in priority order, while there is room, and logs the
+// runs that have to wait for a slot. It must be called with p.mu held.
+func (p *jobPool) dispatch() {
+	for !p.pending && len(p.waiting) > 0 && p.hasRoom() {
+		next := 0
+		for i, t := range p.waiting {
+			if t.priority > p.waiting[next].priority || (t.priority == p.waiting[next].priority && t.seq < p.waiting[next].seq) {
+				next = i
+			}
+		}
+		task := p.waiting[next]
+		p.waiting = append(p.waiting[:next], p.waiting[next+1:]...)
+		p.running++
+		close(task.granted)
+	}
+	if p.pending || p.hasRoom() {
+		return
+	}
+	for _, t := range p.waiting {
+		if !t.blocked {
+			t.blocked = true
+			t.log.Info("Waiting for a free "+t.what+" slot", "limit", p.limit, "priority", t.priority)
+		}
+	}
+}
+
+// httpRateLimit is the HTTP_RATE_LIMIT token bucket that every http request, retries
+// included, draws from. A nil limiter means no limit.
+var httpRateLimit *rateLimiter
+
+// rateLimiter is a token bucket holding up to burst tokens, refilled at rate tokens
+// per second. A nil limiter never blocks.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// reserve takes a token and returns how long the caller has to wait before it may use it.
+func (l *rateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// unreserve gives back a token that was reserved but not used.
+func (l *rateLimiter) unreserve() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = math.Min(l.burst, l.tokens+1)
+}
+
+// wait blocks until a request may be sent or ctx is done, logging when it has to wait.
+func (l *rateLimiter) wait(ctx context.Context, log *slog.Logger) error {
+	if l == nil {
+		return nil
+	}
+	delay := l.reserve()
+	if delay == 0 {
+		return nil
+	}
+	log.Info("Request throttled by HTTP_RATE_LIMIT", "delay", delay.Round(time.Millisecond).String())
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		l.unreserve()
+		return ctx.Err()
+	}
+}
+
+// groupNamePattern restricts group names to characters usable in an env var name,
+// since each group's limit is read from CRON_GROUP_LIMIT_<group>.
+var groupNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// newGroupSemaphores creates one semaphore per concurrency group used by configs,
+// sized by CRON_GROUP_LIMIT_<group> (default 1). A limit below 1 is an error.
+func newGroupSemaphores(configs []Config, logger *slog.Logger) (map[string]semaphore, error) {
+	members := make(map[string][]string)
+	for _, config := range configs {
+		if config.ConcurrencyGroup != "" {
+			members[config.ConcurrencyGroup] = append(members[config.ConcurrencyGroup], config.Name)
+		}
+	}
+
+	groups := make(map[string]semaphore, len(members))
+	names := make([]string, 0, len(members))
+	for group := range members {
+		names = append(names, group)
+	}
+	sort.Strings(names)
+	for _, group := range names {
+		key := fmt.Sprintf("CRON_GROUP_LIMIT_%s", group)
+		limit := 1
+		if v := os.Getenv(key); v == "" {
+			logger.Warn("No limit configured for concurrency group, defaulting to 1", "group", group, "env", key)
+		} else if n, err := strconv.Atoi(v); err != nil || n < 1 {
+			// A group without a limit would be no group at all.
+			return nil, fmt.Errorf("invalid %s %q: must be at least 1", key, v)
+		} else {
+			limit = n
+		}
+		groups[group] = newSemaphore(limit)
+		logger.Info("Concurrency group configured", "group", group, "limit", limit, "jobs", members[group])
+	}
+	return groups, nil
+}
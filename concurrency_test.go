@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+var discardLog = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// waitForWaiting blocks until n runs are waiting in p.
+func waitForWaiting(t *testing.T, p *jobPool, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p.mu.Lock()
+		waiting := len(p.waiting)
+		p.mu.Unlock()
+		if waiting == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("waiting runs = %d, want %d", waiting, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// startOrder queues a run per priority, one after another, behind a run holding the
+// only slot of p, and returns the indexes of the runs in the order they start once
+// it is released.
+func startOrder(t *testing.T, p *jobPool, priorities []int) []int {
+	t.Helper()
+	release, err := p.acquire(context.Background(), discardLog, "job", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i, priority := range priorities {
+		wg.Add(1)
+		go func(i, priority int) {
+			defer wg.Done()
+			release, err := p.acquire(context.Background(), discardLog, "job", priority)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			release()
+		}(i, priority)
+		waitForWaiting(t, p, i+1)
+	}
+	release()
+	wg.Wait()
+	return order
+}
+
+func TestJobPoolStartOrder(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		priorities []int
+		want       []int
+	}{
+		{"highest priority first", []int{1, 5, 3}, []int{1, 2, 0}},
+		{"equal priorities in arrival order", []int{2, 0, 2, 0}, []int{0, 2, 1, 3}},
+		{"without priorities in arrival order", []int{0, 0, 0}, []int{0, 1, 2}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newJobPool(1)
+			configs := make([]Config, len(tc.priorities))
+			for i, priority := range tc.priorities {
+				configs[i].Priority = priority
+			}
+			p.setPriorities(configs)
+			if got := startOrder(t, p, tc.priorities); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("start order = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestJobPoolDropsCancelledWaiters(t *testing.T) {
+	p := newJobPool(1)
+	release, err := p.acquire(context.Background(), discardLog, "job", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := p.acquire(ctx, discardLog, "job", 0)
+		errc <- err
+	}()
+	waitForWaiting(t, p, 1)
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Fatalf("error = %v, want context.Canceled", err)
+	}
+	waitForWaiting(t, p, 0)
+
+	// The slot goes to the next run, not to the cancelled one.
+	release()
+	release, err = p.acquire(context.Background(), discardLog, "job", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestJobPoolWithoutLimitIgnoresPriorities(t *testing.T) {
+	p := newJobPool(0)
+	p.setPriorities([]Config{{Priority: 1}})
+	start := time.Now()
+	release, err := p.acquire(context.Background(), discardLog, "job", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if waited := time.Since(start); waited >= priorityDispatchDelay {
+		t.Errorf("acquire took %v, want no priority dispatch delay without a limit", waited)
+	}
+}
+
+func TestRateLimiterAllowsBurst(t *testing.T) {
+	l := newRateLimiter(1, 3)
+	for i := 0; i < 3; i++ {
+		if delay := l.reserve(); delay != 0 {
+			t.Fatalf("request %d: delay = %v, want none within the burst", i+1, delay)
+		}
+	}
+	if delay := l.reserve(); delay <= 0 || delay > time.Second {
+		t.Errorf("request after the burst: delay = %v, want up to 1s", delay)
+	}
+}
+
+func TestRateLimiterWaitGivesUpOnCancel(t *testing.T) {
+	l := newRateLimiter(0.1, 1) // one request every 10s
+	if err := l.wait(context.Background(), discardLog); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := l.wait(ctx, discardLog); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want context.DeadlineExceeded", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("wait took %v, want it to end with its context", waited)
+	}
+	// The token of the abandoned wait is given back: the next request waits for
+	// the one refill it was owed, not for two.
+	if delay := l.reserve(); delay > 10*time.Second {
+		t.Errorf("delay after a cancelled wait = %v, want at most 10s", delay)
+	}
+}
+
+func TestGroupLimitMustBePositive(t *testing.T) {
+	configs := []Config{{Name: "dump", ConcurrencyGroup: "database"}}
+	for _, tt := range []struct {
+		limit   string
+		wantErr bool
+	}{
+		{"", false},
+		{"2", false},
+		{"0", true},
+		{"-1", true},
+		{"many", true},
+	} {
+		t.Setenv("CRON_GROUP_LIMIT_database", tt.limit)
+		groups, err := newGroupSemaphores(configs, discardLog)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CRON_GROUP_LIMIT_database=%q: err = %v, want error: %v", tt.limit, err, tt.wantErr)
+			continue
+		}
+		if err == nil && groups["database"] == nil {
+			t.Errorf("CRON_GROUP_LIMIT_database=%q: group has no limit", tt.limit)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// validators are the cache validators remembered from a job's last response, plus
+// the body hash that CRON_DETECT_CHANGES compares against.
+type validators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	BodyHash     string `json:"body_hash,omitempty"`
+}
+
+// conditionalCache holds the ETag/Last-Modified values remembered for conditional http
+// jobs. It is set once at startup.
+var conditionalCache *validatorCache
+
+// validatorCache keeps per-job ETag/Last-Modified values for conditional requests and
+// body hashes for change detection. When CONDITIONAL_CACHE_FILE is set the values are
+// also persisted across restarts.
+type validatorCache struct {
+	path   string
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	entries map[string]validators
+}
+
+func newValidatorCacheFromEnv(logger *slog.Logger) *validatorCache {
+	cache := &validatorCache{
+		path:    os.Getenv("CONDITIONAL_CACHE_FILE"),
+		logger:  logger,
+		entries: make(map[string]validators),
+	}
+	if cache.path == "" {
+		return cache
+	}
+
+	data, err := os.ReadFile(cache.path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		logger.Error("Failed to read conditional request cache, starting empty", "path", cache.path, "error", err)
+	default:
+		if err := json.Unmarshal(data, &cache.entries); err != nil {
+			logger.Error("Failed to parse conditional request cache, starting empty", "path", cache.path, "error", err)
+			cache.entries = make(map[string]validators)
+		}
+	}
+	return cache
+}
+
+// apply adds If-None-Match / If-Modified-Since headers from the job's last response.
+func (v *validatorCache) apply(job string, req *http.Request) {
+	v.mu.Lock()
+	val := v.entries[job]
+	v.mu.Unlock()
+
+	if val.ETag != "" {
+		req.Header.Set("If-None-Match", val.ETag)
+	}
+	if val.LastModified != "" {
+		req.Header.Set("If-Modified-Since", val.LastModified)
+	}
+}
+
+// update remembers the validators of a successful (non-304) response.
+func (v *validatorCache) update(job string, resp *http.Response) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	val := v.entries[job]
+	val.ETag = resp.Header.Get("ETag")
+	val.LastModified = resp.Header.Get("Last-Modified")
+	v.store(job, val)
+}
+
+// compareBody records the hash of a job's latest response body and reports whether it
+// differs from the previous one. The first body seen counts as a change.
+func (v *validatorCache) compareBody(job, hash string) (changed bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	val := v.entries[job]
+	changed = val.BodyHash != hash
+	val.BodyHash = hash
+	v.store(job, val)
+	return changed
+}
+
+// store saves the validators of job, persisting them if they changed. v.mu must be held.
+func (v *validatorCache) store(job string, val validators) {
+	if v.entries[job] == val {
+		return
+	}
+	v.entries[job] = val
+	if v.path != "" {
+		if err := writeFileAtomic(v.path, v.entries); err != nil {
+			v.logger.Error("Failed to persist conditional request cache", "path", v.path, "error", err)
+		}
+	}
+}
+
+type changeKey struct{}
+
+// changeOutcome is whether a change-detection run found a change. Known stays false
+// for runs that don't detect changes or that failed before they could tell.
+type changeOutcome struct {
+	Known   bool
+	Changed bool
+}
+
+// withChangeOutcome returns a context through which the run reports its change outcome.
+func withChangeOutcome(ctx context.Context) (context.Context, *changeOutcome) {
+	outcome := &changeOutcome{}
+	return context.WithValue(ctx, changeKey{}, outcome), outcome
+}
+
+// recordChange reports the change outcome of the run ctx belongs to.
+func recordChange(ctx context.Context, changed bool) {
+	if outcome, ok := ctx.Value(changeKey{}).(*changeOutcome); ok {
+		outcome.Known, outcome.Changed = true, changed
+	}
+}
+
+// writeFileAtomic writes value as JSON to path via a temporary file and rename, so a
+// crash never leaves a half-written file behind.
+func writeFileAtomic(path string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeBytesAtomic(path, data)
+}
+
+// writeBytesAtomic replaces the contents of path with data via a temporary file and rename.
+func writeBytesAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
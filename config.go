@@ -0,0 +1,1211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the configuration for a SINGLE cron job.
+type Config struct {
+	Name     string // A friendly name for logging purposes.
+	Schedule string
+	JobType  string // "http" or "shell"
+	// Timezone is the IANA time zone the schedule is evaluated in, instead of the
+	// container's local time.
+	Timezone string
+
+	// ConcurrencyGroup names a group of jobs sharing a semaphore sized by
+	// CRON_GROUP_LIMIT_<group>.
+	ConcurrencyGroup string
+	// Priority orders the runs waiting for the global job pool (MAX_CONCURRENT_JOBS):
+	// higher first. Defaults to 0.
+	Priority int
+
+	// RoutingKey is an alert-routing tag (e.g. a PagerDuty routing key) attached to
+	// failure logs and records so alerts reach the right team.
+	RoutingKey string
+
+	// FlapThreshold enables flap detection: a warning is logged once the flap score
+	// over the last FlapWindow runs reaches this value (0 disables it).
+	FlapThreshold float64
+	FlapWindow    int
+
+	// LogSampleEvery logs only 1 in N successful runs at info level (0 or 1 logs all).
+	LogSampleEvery int
+	// TransientFailures downgrades a failure that directly follows a success to a
+	// warning; only a second consecutive failure is reported as an error.
+	TransientFailures bool
+	// MaxConsecutiveFailures takes the job off the schedule once this many runs in a
+	// row have failed (0 disables it).
+	MaxConsecutiveFailures int
+	// Retries is how often a failed run is retried, with exponential backoff starting
+	// at RetryBackoff.
+	Retries      int
+	RetryBackoff time.Duration
+	// MaxRetriesPerDay caps the job's retries (of every kind) across its runs within
+	// a rolling 24h window; once used up, failed runs aren't retried (0 is unlimited).
+	MaxRetriesPerDay int
+	// StartPingURL is requested (without waiting for it) at the start of every run,
+	// for monitoring services that measure run duration.
+	StartPingURL string
+	// StartAfter delays the first run until this long after process start; the
+	// recurring schedule applies from then on.
+	StartAfter time.Duration
+	// Jitter delays every run by a random duration below it, so the same job in many
+	// containers doesn't fire all at once. 0 keeps the exact schedule.
+	Jitter time.Duration
+	// OverlapPolicy decides what happens to a scheduled run while the previous run is
+	// still in progress: overlapAllow runs it anyway, overlapSkip drops it and
+	// overlapDelay starts it once the previous run has finished.
+	OverlapPolicy string
+
+	// Fields for "http" type
+	TargetURL string
+	// TargetURLs are the URLs of CRON_TARGET_URL; a job with several fans out to all of
+	// them on every run, and FanoutRequireAll fails the run if any of them fails
+	// instead of only if all do.
+	TargetURLs       []string
+	FanoutRequireAll bool
+	// BatchURLTimeout bounds each target of a fan-out, so one slow URL can't hold up
+	// the run, and BatchSuccessThreshold is the percentage of targets that must
+	// succeed for the run to succeed, replacing the all-or-any rule (0 unsets it).
+	BatchURLTimeout       time.Duration
+	BatchSuccessThreshold float64
+	SecretToken           string
+	// AuthType is how the request authenticates: authBearer sends SecretToken as a
+	// bearer token, authBasic sends AuthUser and AuthPass as HTTP Basic credentials,
+	// authHeader sends SecretToken in the AuthHeader header and authNone sends nothing.
+	AuthType   string
+	AuthUser   string
+	AuthPass   string
+	AuthHeader string
+	// HMACSecret signs the request body with HMAC-SHA256; the hex signature is sent
+	// in the HMACHeader header.
+	HMACSecret string
+	HMACHeader string
+	// SecretFiles maps the secret settings read from a KEY_FILE to that file, which
+	// is reloaded when it changes.
+	SecretFiles map[string]string
+	// HTTPTimeout bounds each request attempt, replacing the shared client timeout.
+	HTTPTimeout time.Duration
+	// HTTPMethod is the request method, GET unless CRON_HTTP_METHOD says otherwise.
+	HTTPMethod string
+	// HTTPBody is sent as the request body; HTTPContentType defaults to
+	// application/json when a body is set.
+	HTTPBody        string
+	HTTPContentType string
+	// UserAgent is the User-Agent of the job's requests: CRON_USER_AGENT, else the
+	// global HTTP_USER_AGENT, else defaultUserAgent.
+	UserAgent string
+	// HTTPHeaders are extra request headers; they never replace Authorization.
+	HTTPHeaders map[string]string
+	// AcceptGzip controls response compression: nil leaves it to the Go transport,
+	// true requests gzip explicitly (and decodes it here), false requests identity.
+	AcceptGzip *bool
+	// ExpectRedirect disables redirect following and requires a 3xx response whose
+	// Location matches this URL.
+	ExpectRedirect string
+	// Conditional sends If-None-Match/If-Modified-Since from the previous response;
+	// a 304 Not Modified is then an "unchanged" success.
+	Conditional bool
+	// DetectChanges compares a hash of each response body with the previous one, so
+	// runs that found nothing new are reported as no-ops.
+	DetectChanges bool
+	// Preflight does a quick TCP connect to the target before the HTTP request so
+	// connectivity problems are reported separately from HTTP errors.
+	Preflight bool
+	// CAFile adds a private CA to the certificates trusted for the target, and
+	// ClientCert and ClientKey hold the client certificate sent for mutual TLS.
+	CAFile     string
+	ClientCert string
+	ClientKey  string
+	// InsecureSkipVerify disables verification of the target's certificate.
+	InsecureSkipVerify bool
+	// DialTimeout and DialKeepAlive tune the job's own net.Dialer; zero keeps Go's
+	// defaults and a negative keep-alive disables TCP keep-alive probes.
+	DialTimeout   time.Duration
+	DialKeepAlive time.Duration
+	// DNSRefresh makes the job re-resolve its target host: a positive interval drops
+	// pooled connections that old, a negative value uses a new connection every run.
+	DNSRefresh time.Duration
+	// ConnRetries and RespRetries are the retry budgets for attempts that failed to
+	// connect and for 5xx responses, each with its own initial backoff.
+	ConnRetries      int
+	ConnRetryBackoff time.Duration
+	RespRetries      int
+	RespRetryBackoff time.Duration
+	// RetryOnStatus lists the response statuses that are retried; nil retries 5xx.
+	RetryOnStatus []statusRange
+	// RetryUnsafe allows repeating requests of non-idempotent methods (POST, PATCH)
+	// that the server may already have acted on.
+	RetryUnsafe bool
+	// SuccessStatus lists the response statuses that count as success; nil accepts
+	// every status below 400.
+	SuccessStatus []statusRange
+	// SlowThreshold flags successful runs that take longer than this as slow.
+	SlowThreshold time.Duration
+	// AWSSigV4 signs requests with AWS Signature Version 4 for AWSRegion and
+	// AWSService instead of sending CRON_SECRET as a bearer token.
+	AWSSigV4   bool
+	AWSRegion  string
+	AWSService string
+
+	// Fields for "shell" type
+	ShellCommand         string
+	ShellTargetContainer string
+	// ShellTargets runs a different command per container as one logical job.
+	ShellTargets         []shellTarget
+	ShellTargetsParallel bool
+	// ShellPreCommand and ShellPostCommand run before and after the main command, where
+	// SHELL_COMMAND runs. The post-command runs even if an earlier phase failed.
+	ShellPreCommand  string
+	ShellPostCommand string
+	// ShellEnv holds KEY=VALUE entries added to the command's environment.
+	ShellEnv []string
+	// ShellExpandVars replaces ${NAME} references in the command before it runs.
+	ShellExpandVars bool
+	// ShellTemplate renders the placeholders of the job's commands. Without it they
+	// run verbatim, so e.g. docker ps --format '{{.Names}}' keeps its braces.
+	ShellTemplate bool
+	// ShellAllocatePTY runs the command attached to a pseudo-terminal, for tools that
+	// behave differently without one.
+	ShellAllocatePTY bool
+	// ShellWorkdir is the working directory of local commands.
+	ShellWorkdir string
+	// ShellDockerUser and ShellDockerWorkdir are the user and working directory of
+	// docker exec commands (docker exec -u/-w).
+	ShellDockerUser    string
+	ShellDockerWorkdir string
+	// ShellWaitForContainer is how long a docker exec command that failed because the
+	// target container is not running waits for it to run again before one more
+	// attempt. Zero fails right away.
+	ShellWaitForContainer time.Duration
+	// ShellInterpreter runs the command as "<interpreter> -c <command>".
+	ShellInterpreter string
+	// ShellSuccessCodes are the exit codes that count as success; nil means only 0.
+	ShellSuccessCodes []int
+	// ShellTimeout kills the command if it runs longer.
+	ShellTimeout time.Duration
+	// ShellRedactPatterns mask matching parts of the command output before it is logged.
+	ShellRedactPatterns []*regexp.Regexp
+	// ShellFailIfOutput and ShellRequireOutput judge a command that exited successfully by its
+	// output, for tools with unreliable exit codes.
+	ShellFailIfOutput  *regexp.Regexp
+	ShellRequireOutput *regexp.Regexp
+	// ShellMaxStdout and ShellMaxStderr cap the captured output (in bytes) of each
+	// stream; anything beyond is dropped and marked as truncated. 0 means no limit.
+	ShellMaxStdout int
+	ShellMaxStderr int
+
+	// Fields for "cert_expiry" type
+	CertHost     string // host:port
+	CertWarnDays int
+
+	// Fields for "docker_run" type, which also uses ShellCommand and ShellRedactPatterns
+	DockerImage string
+	// DockerEnv holds KEY=VALUE (or KEY, to pass the runner's value through) entries.
+	DockerEnv     []string
+	DockerVolumes []string
+	// DockerRemove removes the container once the command exits (docker run --rm).
+	DockerRemove bool
+}
+
+// redacted returns a copy of the config that is safe to log or persist, with secret
+// values masked.
+func (c Config) redacted() Config {
+	if c.SecretToken != "" {
+		c.SecretToken = "***"
+	}
+	if c.AuthPass != "" {
+		c.AuthPass = "***"
+	}
+	if c.HMACSecret != "" {
+		c.HMACSecret = "***"
+	}
+	if len(c.HTTPHeaders) > 0 {
+		headers := make(map[string]string, len(c.HTTPHeaders))
+		for key := range c.HTTPHeaders {
+			headers[key] = "***"
+		}
+		c.HTTPHeaders = headers
+	}
+	c.ShellEnv = maskEnv(c.ShellEnv)
+	c.DockerEnv = maskEnv(c.DockerEnv)
+	return c
+}
+
+// maskEnv returns a copy of KEY=VALUE entries with the values masked.
+func maskEnv(entries []string) []string {
+	if len(entries) == 0 {
+		return entries
+	}
+	env := make([]string, len(entries))
+	for i, e := range entries {
+		if name, _, ok := strings.Cut(e, "="); ok {
+			e = name + "=***"
+		}
+		env[i] = e
+	}
+	return env
+}
+
+// usesDockerExec reports whether the job runs any command via docker exec.
+func (c Config) usesDockerExec() bool {
+	if c.ShellTargetContainer != "" {
+		return true
+	}
+	for _, t := range c.ShellTargets {
+		if t.Container != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// envBool reports whether the environment variable key holds a true value ("1", "true", ...).
+func envBool(key string) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	return err == nil && v
+}
+
+// envInt parses the environment variable key as a non-negative integer. Unset or
+// invalid values fall back to def; invalid values are logged.
+func envInt(logger *slog.Logger, key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		logger.Error("Invalid "+key+", using default", "value", v, "default", def)
+		return def
+	}
+	return n
+}
+
+// envFloat parses the environment variable key as a non-negative number. Unset or
+// invalid values fall back to def; invalid values are logged.
+func envFloat(logger *slog.Logger, key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+		logger.Error("Invalid "+key+", using default", "value", v, "default", def)
+		return def
+	}
+	return f
+}
+
+// envTime parses the environment variable key as an RFC 3339 timestamp. Unset or
+// invalid values fall back to def; invalid values are logged.
+func envTime(logger *slog.Logger, key string, def time.Time) time.Time {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		logger.Error("Invalid "+key+", using default", "value", v, "default", def.Format(time.RFC3339))
+		return def
+	}
+	return t
+}
+
+// envDuration parses the environment variable key as a non-negative time.Duration.
+// Unset or invalid values fall back to def; invalid values are logged.
+func envDuration(logger *slog.Logger, key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		logger.Error("Invalid "+key+", using default", "value", v, "default", def.String())
+		return def
+	}
+	return d
+}
+
+// jobSource resolves the settings of a single job definition and records where each
+// value came from, so the effective configuration can be traced back to its source.
+type jobSource struct {
+	// lookup returns the raw value of a setting (e.g. "CRON_SCHEDULE") and a
+	// description of where it is defined.
+	lookup func(key string) (value, origin string)
+
+	sources map[string]string
+	values  map[string]string
+}
+
+// secretSettings are never shown in configuration traces; only their source is.
+var secretSettings = map[string]bool{
+	"CRON_SECRET":      true,
+	"CRON_AUTH_PASS":   true,
+	"CRON_HMAC_SECRET": true,
+	"DOCKER_ENV":       true,
+	"SHELL_ENV":        true,
+	// Custom headers often carry API keys.
+	"CRON_HTTP_HEADERS": true,
+}
+
+// newEnvJobSource returns the source for the job with index i, defined by the
+// indexed environment variables KEY_i.
+func newEnvJobSource(i int) *jobSource {
+	return &jobSource{
+		lookup: func(key string) (string, string) {
+			name := fmt.Sprintf("%s_%d", key, i)
+			return os.Getenv(name), "env:" + name
+		},
+		sources: make(map[string]string),
+		values:  make(map[string]string),
+	}
+}
+
+// get returns the value of setting key, or "" if it is not set.
+func (s *jobSource) get(key string) string {
+	v, origin := s.lookup(key)
+	if v != "" {
+		s.record(key, v, origin)
+	}
+	return v
+}
+
+// getBool reports whether setting key holds a true value ("1", "true", ...).
+func (s *jobSource) getBool(key string) bool {
+	v, err := strconv.ParseBool(s.get(key))
+	return err == nil && v
+}
+
+// getSecret is get for secrets, which can also be read from the file named by the
+// KEY_FILE setting (e.g. a Docker or Kubernetes secret) to keep them out of the
+// environment. A trailing newline in the file is dropped.
+func (s *jobSource) getSecret(key string) (string, error) {
+	v := s.get(key)
+	path := s.get(key + "_FILE")
+	if path == "" {
+		return v, nil
+	}
+	if v != "" {
+		return "", fmt.Errorf("%s and %s_FILE are both set, use only one", key, key)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s_FILE: %w", key, err)
+	}
+	v = strings.TrimRight(string(data), "\r\n")
+	s.record(key, v, "file:"+path)
+	return v, nil
+}
+
+// setDefault records that setting key took a default value.
+func (s *jobSource) setDefault(key, value string) {
+	s.record(key, value, "default")
+}
+
+// override records that setting key was changed after loading, by origin.
+func (s *jobSource) override(key, value, origin string) {
+	s.record(key, value, "override:"+origin)
+}
+
+func (s *jobSource) record(key, value, origin string) {
+	if secretSettings[key] {
+		value = "***"
+	}
+	s.sources[key] = origin
+	s.values[key] = value
+}
+
+// loadConfigs loads configurations for ALL jobs: first those of CONFIG_FILE, then
+// those defined in the CRON_JOBS_JSON array, then the indexed environment variables
+// (CRON_SCHEDULE_1, ...). With CONFIG_TRACE enabled, the effective settings of every
+// job are logged along with the source of each value. Invalid jobs are logged and
+// skipped; invalid is their number.
+//
+// A configured but unreadable CONFIG_FILE is fatal rather than silently starting
+// without its jobs.
+func loadConfigs(logger *slog.Logger) (configs []Config, invalid int) {
+	configs, invalid, err := readConfigs(logger)
+	if err != nil {
+		fields := []interface{}{"path", os.Getenv("CONFIG_FILE"), "error", err}
+		if errors.Is(err, fs.ErrNotExist) {
+			fields = append(fields, "config_file_missing_policy", missingFileError)
+		}
+		logger.Error("Failed to load CONFIG_FILE", fields...)
+		os.Exit(1)
+	}
+	return configs, invalid
+}
+
+// CONFIG_FILE_MISSING_POLICY values: what happens when CONFIG_FILE doesn't exist.
+const (
+	missingFileError    = "error"    // fail: the runner doesn't start, a reload is rejected
+	missingFileFallback = "fallback" // load the jobs of the environment variables only
+	missingFileEmpty    = "empty"    // load no jobs at all, and keep running without them
+)
+
+// configFileMissingPolicy returns CONFIG_FILE_MISSING_POLICY, missingFileError by default.
+func configFileMissingPolicy(logger *slog.Logger) string {
+	switch v := strings.ToLower(os.Getenv("CONFIG_FILE_MISSING_POLICY")); v {
+	case "":
+		return missingFileError
+	case missingFileError, missingFileFallback, missingFileEmpty:
+		return v
+	default:
+		logger.Error("Invalid CONFIG_FILE_MISSING_POLICY, using default", "value", v, "default", missingFileError)
+		return missingFileError
+	}
+}
+
+// readConfigs is loadConfigs, returning an unreadable CONFIG_FILE as err. A missing
+// CONFIG_FILE is handled according to CONFIG_FILE_MISSING_POLICY.
+func readConfigs(logger *slog.Logger) (configs []Config, invalid int, err error) {
+	trace := envBool("CONFIG_TRACE")
+
+	load := func(src *jobSource, defaultName string) {
+		config, validationError := parseJob(src, defaultName, logger)
+		if trace {
+			logger.Info("Job configuration trace", "job_name", config.Name, "values", src.values, "sources", src.sources)
+		}
+
+		if validationError != nil {
+			logger.Error("Skipping invalid job configuration", "job_name", config.Name, "reason", validationError)
+			invalid++
+			return // Skip this job and move to the next one
+		}
+
+		configs = append(configs, config)
+		logger.Info("Successfully loaded job configuration", "job_name", config.Name, "schedule", config.Schedule, "type", config.JobType)
+	}
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		sources, err := newFileJobSources(path)
+		policy := configFileMissingPolicy(logger)
+		switch {
+		case errors.Is(err, fs.ErrNotExist) && policy == missingFileFallback:
+			logger.Warn("CONFIG_FILE is missing, loading the jobs of the environment variables only", "path", path, "config_file_missing_policy", policy)
+		case errors.Is(err, fs.ErrNotExist) && policy == missingFileEmpty:
+			logger.Warn("CONFIG_FILE is missing, running without jobs", "path", path, "config_file_missing_policy", policy)
+			return nil, 0, nil
+		case err != nil:
+			return nil, 0, err
+		}
+		if err == nil {
+			logger.Info("Loading jobs from CONFIG_FILE", "path", path, "jobs", len(sources))
+		}
+		for i, src := range sources {
+			load(src, fmt.Sprintf("file_job_#%d", i+1))
+		}
+	}
+
+	if raw := os.Getenv("CRON_JOBS_JSON"); raw != "" {
+		sources, err := newJSONJobSources(raw)
+		if err != nil {
+			logger.Error("Skipping invalid CRON_JOBS_JSON", "reason", err)
+			invalid++
+		}
+		for i, src := range sources {
+			load(src, fmt.Sprintf("json_job_#%d", i+1))
+		}
+	}
+
+	// Search for jobs in an infinite loop, looking for CRON_SCHEDULE_i
+	for i := 1; ; i++ {
+		src := newEnvJobSource(i)
+
+		// If a schedule for the current index is not found, we assume there are no more jobs.
+		if src.get("CRON_SCHEDULE") == "" {
+			break
+		}
+		load(src, fmt.Sprintf("job_#%d", i))
+	}
+
+	return configs, invalid, nil
+}
+
+// defaultShellInterpreter is the default SHELL_INTERPRETER.
+const defaultShellInterpreter = "sh"
+
+// defaultShellTimeout is the default SHELL_TIMEOUT.
+const defaultShellTimeout = 5 * time.Minute
+
+// defaultShellOutputLimit is the default SHELL_MAX_STDOUT/SHELL_MAX_STDERR, in bytes.
+const defaultShellOutputLimit = 64 * 1024
+
+// resolveDuplicateNames makes job names unique, since logs, records and per-job state
+// are keyed by name. A duplicate gets a " (2)", " (3)", ... suffix; under STRICT_CONFIG
+// (strict) duplicates are reported instead and false is returned.
+func resolveDuplicateNames(logger *slog.Logger, configs []Config, strict bool) bool {
+	ok := true
+	taken := make(map[string]bool, len(configs))
+	for i := range configs {
+		name := configs[i].Name
+		if !taken[name] {
+			taken[name] = true
+			continue
+		}
+		if strict {
+			logger.Error("Duplicate job name", "job_name", name, "resolution", "refusing to start under STRICT_CONFIG")
+			ok = false
+			continue
+		}
+		unique := name
+		for n := 2; taken[unique]; n++ {
+			unique = fmt.Sprintf("%s (%d)", name, n)
+		}
+		taken[unique] = true
+		configs[i].Name = unique
+		logger.Warn("Duplicate job name", "job_name", name, "resolution", "renamed", "new_job_name", unique, "schedule", configs[i].Schedule)
+	}
+	return ok
+}
+
+// parseShellSettings reads the settings shared by shell-like jobs: the command
+// timeout and how the command output is processed.
+func parseShellSettings(src *jobSource, config *Config) error {
+	var validationError error
+	config.ShellTimeout = defaultShellTimeout
+	if v := src.get("SHELL_TIMEOUT"); v == "" {
+		src.setDefault("SHELL_TIMEOUT", defaultShellTimeout.String())
+	} else if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+		validationError = fmt.Errorf("invalid SHELL_TIMEOUT %q: must be a positive duration", v)
+	} else {
+		config.ShellTimeout = d
+	}
+	config.ShellTemplate = src.getBool("SHELL_TEMPLATE")
+	config.ShellInterpreter = src.get("SHELL_INTERPRETER")
+	if config.ShellInterpreter == "" {
+		config.ShellInterpreter = defaultShellInterpreter
+		src.setDefault("SHELL_INTERPRETER", defaultShellInterpreter)
+	} else if strings.ContainsAny(config.ShellInterpreter, " \t") {
+		validationError = fmt.Errorf("invalid SHELL_INTERPRETER %q: must be a single executable name or path", config.ShellInterpreter)
+	}
+	if v := src.get("SHELL_SUCCESS_CODES"); v == "" {
+		src.setDefault("SHELL_SUCCESS_CODES", "0")
+	} else if codes, err := parseExitCodes(v); err != nil {
+		validationError = fmt.Errorf("invalid SHELL_SUCCESS_CODES: %w", err)
+	} else {
+		config.ShellSuccessCodes = codes
+	}
+	if v := src.get("SHELL_REDACT_PATTERNS"); v != "" {
+		patterns, err := compilePatterns(v)
+		if err != nil {
+			validationError = fmt.Errorf("invalid SHELL_REDACT_PATTERNS: %w", err)
+		}
+		config.ShellRedactPatterns = patterns
+	}
+	for _, limit := range []struct {
+		key   string
+		value *int
+	}{
+		{"SHELL_MAX_STDOUT", &config.ShellMaxStdout},
+		{"SHELL_MAX_STDERR", &config.ShellMaxStderr},
+	} {
+		*limit.value = defaultShellOutputLimit
+		if v := src.get(limit.key); v == "" {
+			src.setDefault(limit.key, strconv.Itoa(defaultShellOutputLimit))
+		} else if n, err := strconv.Atoi(v); err != nil || n < 0 {
+			validationError = fmt.Errorf("invalid %s %q: must be a non-negative number of bytes", limit.key, v)
+		} else {
+			*limit.value = n
+		}
+	}
+	if v := src.get("SHELL_FAIL_IF_OUTPUT_MATCHES"); v != "" {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			validationError = fmt.Errorf("invalid SHELL_FAIL_IF_OUTPUT_MATCHES: %w", err)
+		}
+		config.ShellFailIfOutput = re
+	}
+	if v := src.get("SHELL_REQUIRE_OUTPUT_MATCHES"); v != "" {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			validationError = fmt.Errorf("invalid SHELL_REQUIRE_OUTPUT_MATCHES: %w", err)
+		}
+		config.ShellRequireOutput = re
+	}
+	return validationError
+}
+
+// parseJob builds and validates the configuration of one job from src. defaultName is
+// used when the job has no JOB_NAME. The returned error describes why the job is
+// invalid; the config is returned regardless so the job can be named in logs.
+func parseJob(src *jobSource, defaultName string, logger *slog.Logger) (Config, error) {
+	schedule := src.get("CRON_SCHEDULE")
+
+	jobType := src.get("JOB_TYPE")
+	if jobType == "" {
+		jobType = "http" // Default job type
+		src.setDefault("JOB_TYPE", jobType)
+	}
+
+	jobName := src.get("JOB_NAME")
+	if jobName == "" {
+		jobName = defaultName // Default job name
+		src.setDefault("JOB_NAME", jobName)
+	}
+
+	config := Config{
+		Name:     jobName,
+		Schedule: schedule,
+		JobType:  jobType,
+	}
+
+	var validationError error
+	// secret reads a secret setting, logging where it came from but not its value. A
+	// secret that can't be read is reported rather than the missing value it causes.
+	var secretError error
+	secret := func(key string) string {
+		v, err := src.getSecret(key)
+		if err != nil {
+			secretError = err
+		} else if v != "" {
+			logger.Info("Loaded secret", "job_name", jobName, "setting", key, "source", src.sources[key])
+		}
+		if path := src.get(key + "_FILE"); path != "" && err == nil {
+			if config.SecretFiles == nil {
+				config.SecretFiles = make(map[string]string)
+			}
+			config.SecretFiles[key] = path
+		}
+		return v
+	}
+	if schedule == "" {
+		validationError = errors.New("CRON_SCHEDULE is required")
+	}
+	if v := src.get("CRON_TZ"); v != "" {
+		schedulePrefix := strings.ToUpper(schedule)
+		if _, err := time.LoadLocation(v); err != nil {
+			validationError = fmt.Errorf("invalid CRON_TZ %q: %w", v, err)
+		} else if strings.HasPrefix(schedulePrefix, "TZ=") || strings.HasPrefix(schedulePrefix, "CRON_TZ=") {
+			validationError = errors.New("CRON_TZ cannot be combined with a time zone prefix in CRON_SCHEDULE")
+		}
+		config.Timezone = v
+	}
+	if schedule != "" && schedule != scheduleAtStartup && validationError == nil {
+		if _, err := parseSchedule(config); err != nil {
+			validationError = fmt.Errorf("invalid CRON_SCHEDULE %q: %w", schedule, err)
+		}
+	}
+	config.RoutingKey = src.get("CRON_ROUTING_KEY")
+	if v := src.get("CRON_PRIORITY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			validationError = fmt.Errorf("invalid CRON_PRIORITY %q: must be an integer", v)
+		}
+		config.Priority = n
+	}
+	config.ConcurrencyGroup = src.get("CRON_CONCURRENCY_GROUP")
+	if config.ConcurrencyGroup != "" && !groupNamePattern.MatchString(config.ConcurrencyGroup) {
+		validationError = fmt.Errorf("invalid CRON_CONCURRENCY_GROUP %q: only letters, digits and underscores are allowed", config.ConcurrencyGroup)
+	}
+
+	if v := src.get("CRON_FLAP_THRESHOLD"); v != "" {
+		threshold, err := strconv.ParseFloat(v, 64)
+		if err != nil || threshold <= 0 || threshold > 1 {
+			validationError = fmt.Errorf("invalid CRON_FLAP_THRESHOLD %q: must be a number in (0, 1]", v)
+		}
+		config.FlapThreshold = threshold
+		config.FlapWindow = 10
+		if w := src.get("CRON_FLAP_WINDOW"); w == "" {
+			src.setDefault("CRON_FLAP_WINDOW", "10")
+		} else {
+			n, err := strconv.Atoi(w)
+			if err != nil || n < 3 {
+				validationError = fmt.Errorf("invalid CRON_FLAP_WINDOW %q: must be an integer >= 3", w)
+			}
+			config.FlapWindow = n
+		}
+	}
+
+	config.TransientFailures = src.getBool("CRON_TRANSIENT_FAILURES")
+	config.StartPingURL = src.get("CRON_START_PING_URL")
+
+	if v := src.get("CRON_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			validationError = fmt.Errorf("invalid CRON_RETRIES %q: must be a non-negative integer", v)
+		}
+		config.Retries = n
+	}
+	if v := src.get("CRON_MAX_RETRIES_PER_DAY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			validationError = fmt.Errorf("invalid CRON_MAX_RETRIES_PER_DAY %q: must be a non-negative integer", v)
+		}
+		config.MaxRetriesPerDay = n
+	}
+	if v := src.get("CRON_MAX_CONSECUTIVE_FAILURES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			validationError = fmt.Errorf("invalid CRON_MAX_CONSECUTIVE_FAILURES %q: must be a non-negative integer", v)
+		}
+		config.MaxConsecutiveFailures = n
+	}
+	config.RetryBackoff = time.Second
+	if v := src.get("CRON_RETRY_BACKOFF"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			validationError = fmt.Errorf("invalid CRON_RETRY_BACKOFF %q: must be a positive duration", v)
+		}
+		config.RetryBackoff = d
+	} else if config.Retries > 0 {
+		src.setDefault("CRON_RETRY_BACKOFF", config.RetryBackoff.String())
+	}
+
+	if v := src.get("CRON_START_AFTER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			validationError = fmt.Errorf("invalid CRON_START_AFTER %q: must be a positive duration", v)
+		}
+		config.StartAfter = d
+		if schedule == scheduleAtStartup {
+			validationError = errors.New("CRON_START_AFTER cannot be combined with the @startup schedule")
+		}
+	}
+	if v := src.get("CRON_JITTER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			validationError = fmt.Errorf("invalid CRON_JITTER %q: must be a non-negative duration", v)
+		}
+		config.Jitter = d
+	}
+	config.OverlapPolicy = strings.ToLower(src.get("CRON_OVERLAP_POLICY"))
+	switch config.OverlapPolicy {
+	case "":
+		// CRON_SKIP_IF_RUNNING predates CRON_OVERLAP_POLICY and is kept as a shorthand.
+		config.OverlapPolicy = overlapAllow
+		if src.getBool("CRON_SKIP_IF_RUNNING") {
+			config.OverlapPolicy = overlapSkip
+		}
+		src.setDefault("CRON_OVERLAP_POLICY", config.OverlapPolicy)
+	case overlapAllow, overlapSkip, overlapDelay:
+		if src.get("CRON_SKIP_IF_RUNNING") != "" {
+			validationError = errors.New("CRON_SKIP_IF_RUNNING cannot be combined with CRON_OVERLAP_POLICY")
+		}
+	default:
+		validationError = fmt.Errorf("invalid CRON_OVERLAP_POLICY %q: must be one of allow, skip, delay", config.OverlapPolicy)
+	}
+
+	if sample := src.get("CRON_LOG_SAMPLE"); sample != "" {
+		n, err := parseLogSample(sample)
+		if err != nil {
+			validationError = fmt.Errorf("invalid CRON_LOG_SAMPLE: %w", err)
+		}
+		config.LogSampleEvery = n
+	}
+
+	switch jobType {
+	case "http":
+		config.TargetURL = src.get("CRON_TARGET_URL")
+		if v := src.get("CRON_HTTP_TIMEOUT"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				validationError = fmt.Errorf("invalid CRON_HTTP_TIMEOUT %q: must be a positive duration", v)
+			}
+			config.HTTPTimeout = d
+		}
+		if v := src.get("CRON_HTTP_HEADERS"); v != "" {
+			headers, err := parseHeaders(v)
+			if err != nil {
+				validationError = fmt.Errorf("invalid CRON_HTTP_HEADERS: %w", err)
+			}
+			config.HTTPHeaders = headers
+		}
+		config.HTTPBody = src.get("CRON_HTTP_BODY")
+		config.HTTPContentType = src.get("CRON_HTTP_CONTENT_TYPE")
+		if _, ok := config.HTTPHeaders["Content-Type"]; ok && config.HTTPContentType == "" {
+			config.HTTPContentType = config.HTTPHeaders["Content-Type"]
+		}
+		if config.HTTPBody != "" && config.HTTPContentType == "" {
+			config.HTTPContentType = "application/json"
+			src.setDefault("CRON_HTTP_CONTENT_TYPE", config.HTTPContentType)
+		}
+		config.UserAgent = src.get("CRON_USER_AGENT")
+		if config.UserAgent == "" {
+			if v := os.Getenv("HTTP_USER_AGENT"); v != "" {
+				config.UserAgent = v
+				src.record("CRON_USER_AGENT", v, "env:HTTP_USER_AGENT")
+			} else {
+				config.UserAgent = defaultUserAgent
+				src.setDefault("CRON_USER_AGENT", defaultUserAgent)
+			}
+		}
+		config.HTTPMethod = strings.ToUpper(src.get("CRON_HTTP_METHOD"))
+		switch config.HTTPMethod {
+		case "":
+			config.HTTPMethod = http.MethodGet
+			src.setDefault("CRON_HTTP_METHOD", config.HTTPMethod)
+		case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodHead:
+		default:
+			validationError = fmt.Errorf("invalid CRON_HTTP_METHOD %q: must be one of GET, POST, PUT, PATCH, DELETE, HEAD", config.HTTPMethod)
+		}
+		config.SecretToken = secret("CRON_SECRET")
+		if config.TargetURL == "" {
+			validationError = errors.New("CRON_TARGET_URL is required")
+		}
+		config.TargetURLs = parseTargetURLs(config.TargetURL)
+		for _, target := range config.TargetURLs {
+			if _, err := renderTemplate(target, jobNow(config), config.Name); err != nil {
+				validationError = fmt.Errorf("invalid CRON_TARGET_URL: %w", err)
+			}
+		}
+		config.FanoutRequireAll = src.getBool("CRON_FANOUT_REQUIRE_ALL")
+		if v := src.get("BATCH_URL_TIMEOUT"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				validationError = fmt.Errorf("invalid BATCH_URL_TIMEOUT %q: must be a positive duration", v)
+			}
+			config.BatchURLTimeout = d
+		}
+		if v := src.get("BATCH_SUCCESS_THRESHOLD"); v != "" {
+			percent, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+			if err != nil || percent <= 0 || percent > 100 {
+				validationError = fmt.Errorf("invalid BATCH_SUCCESS_THRESHOLD %q: must be a percentage above 0 and at most 100, e.g. 90%%", v)
+			}
+			if config.FanoutRequireAll {
+				validationError = errors.New("BATCH_SUCCESS_THRESHOLD can't be combined with CRON_FANOUT_REQUIRE_ALL")
+			}
+			config.BatchSuccessThreshold = percent
+		}
+		config.AWSSigV4 = src.getBool("CRON_AWS_SIGV4")
+		if config.AWSSigV4 {
+			config.AWSRegion = src.get("CRON_AWS_REGION")
+			config.AWSService = src.get("CRON_AWS_SERVICE")
+			if config.AWSRegion == "" || config.AWSService == "" {
+				validationError = errors.New("CRON_AWS_REGION and CRON_AWS_SERVICE are required with CRON_AWS_SIGV4")
+			}
+			if !sigV4Supported {
+				validationError = errors.New("CRON_AWS_SIGV4 requires a binary built with -tags sigv4")
+			}
+		}
+		config.AuthType = strings.ToLower(src.get("CRON_AUTH_TYPE"))
+		switch config.AuthType {
+		case "":
+			config.AuthType = authBearer
+			src.setDefault("CRON_AUTH_TYPE", config.AuthType)
+			fallthrough
+		case authBearer:
+			if !config.AWSSigV4 && config.SecretToken == "" {
+				validationError = errors.New("CRON_SECRET is required")
+			}
+		case authBasic:
+			config.AuthUser = src.get("CRON_AUTH_USER")
+			config.AuthPass = secret("CRON_AUTH_PASS")
+			if config.AuthUser == "" {
+				validationError = errors.New("CRON_AUTH_USER is required with CRON_AUTH_TYPE basic")
+			}
+			if config.AWSSigV4 {
+				validationError = errors.New("CRON_AUTH_TYPE basic can't be combined with CRON_AWS_SIGV4, both use the Authorization header")
+			}
+		case authHeader:
+			config.AuthHeader = src.get("CRON_AUTH_HEADER")
+			if config.AuthHeader == "" || strings.ContainsAny(config.AuthHeader, " \t:") {
+				validationError = fmt.Errorf("invalid CRON_AUTH_HEADER %q: a header name is required with CRON_AUTH_TYPE header", config.AuthHeader)
+			}
+			if config.SecretToken == "" {
+				validationError = errors.New("CRON_SECRET is required")
+			}
+		case authNone:
+		default:
+			validationError = fmt.Errorf("invalid CRON_AUTH_TYPE %q: must be one of bearer, basic, header, none", config.AuthType)
+		}
+		config.HMACSecret = secret("CRON_HMAC_SECRET")
+		if config.HMACSecret != "" {
+			config.HMACHeader = src.get("CRON_HMAC_HEADER")
+			if config.HMACHeader == "" {
+				config.HMACHeader = defaultHMACHeader
+				src.setDefault("CRON_HMAC_HEADER", defaultHMACHeader)
+			} else if strings.ContainsAny(config.HMACHeader, " \t:") {
+				validationError = fmt.Errorf("invalid CRON_HMAC_HEADER %q: must be a header name", config.HMACHeader)
+			}
+		}
+		config.ExpectRedirect = src.get("CRON_EXPECT_REDIRECT")
+		if v := src.get("CRON_SUCCESS_STATUS"); v != "" {
+			ranges, err := parseStatusRanges(v)
+			if err != nil {
+				validationError = fmt.Errorf("invalid CRON_SUCCESS_STATUS: %w", err)
+			}
+			config.SuccessStatus = ranges
+		}
+		config.Conditional = src.getBool("CRON_CONDITIONAL")
+		config.DetectChanges = src.getBool("CRON_DETECT_CHANGES")
+		if len(config.TargetURLs) > 1 && (config.Conditional || config.DetectChanges) {
+			// Their validators and body hashes are kept per job, not per target.
+			validationError = errors.New("CRON_CONDITIONAL and CRON_DETECT_CHANGES can't be used with several CRON_TARGET_URLs")
+		}
+		config.Preflight = src.getBool("CRON_PREFLIGHT")
+		if v := src.get("CRON_SLOW_THRESHOLD"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				validationError = fmt.Errorf("invalid CRON_SLOW_THRESHOLD %q: must be a positive duration", v)
+			}
+			config.SlowThreshold = d
+		}
+		config.CAFile = src.get("CRON_CA_FILE")
+		config.ClientCert = src.get("CRON_CLIENT_CERT")
+		config.ClientKey = src.get("CRON_CLIENT_KEY")
+		config.InsecureSkipVerify = src.getBool("CRON_INSECURE_SKIP_VERIFY")
+		if config.InsecureSkipVerify {
+			logger.Warn("TLS certificate verification is disabled for job, its requests can be intercepted", "job_name", config.Name, "target", redactURLs(config.TargetURLs))
+		}
+		if (config.ClientCert == "") != (config.ClientKey == "") {
+			validationError = errors.New("CRON_CLIENT_CERT and CRON_CLIENT_KEY must be set together")
+		} else if _, err := jobTLSConfig(config); err != nil {
+			// Unreadable files fail the job at startup rather than at its first run.
+			validationError = err
+		}
+		if v := src.get("CRON_DIAL_TIMEOUT"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				validationError = fmt.Errorf("invalid CRON_DIAL_TIMEOUT %q: must be a positive duration", v)
+			}
+			config.DialTimeout = d
+		}
+		if v := src.get("CRON_DIAL_KEEPALIVE"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d == 0 {
+				validationError = fmt.Errorf("invalid CRON_DIAL_KEEPALIVE %q: must be a non-zero duration", v)
+			}
+			config.DialKeepAlive = d
+		}
+		for _, retry := range []struct {
+			countKey, backoffKey string
+			count                *int
+			backoff              *time.Duration
+			defaultBackoff       time.Duration
+		}{
+			{"CRON_CONN_RETRIES", "CRON_CONN_RETRY_BACKOFF", &config.ConnRetries, &config.ConnRetryBackoff, time.Second},
+			{"CRON_RESP_RETRIES", "CRON_RESP_RETRY_BACKOFF", &config.RespRetries, &config.RespRetryBackoff, 5 * time.Second},
+		} {
+			if v := src.get(retry.countKey); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 {
+					validationError = fmt.Errorf("invalid %s %q: must be a non-negative integer", retry.countKey, v)
+				}
+				*retry.count = n
+			}
+			*retry.backoff = retry.defaultBackoff
+			if v := src.get(retry.backoffKey); v != "" {
+				d, err := time.ParseDuration(v)
+				if err != nil || d <= 0 {
+					validationError = fmt.Errorf("invalid %s %q: must be a positive duration", retry.backoffKey, v)
+				}
+				*retry.backoff = d
+			}
+		}
+		if v := src.get("CRON_RETRY_ON_STATUS"); v != "" {
+			ranges, err := parseStatusRanges(v)
+			if err != nil {
+				validationError = fmt.Errorf("invalid CRON_RETRY_ON_STATUS: %w", err)
+			}
+			config.RetryOnStatus = ranges
+		}
+		config.RetryUnsafe = src.getBool("CRON_RETRY_UNSAFE")
+		if v := src.get("CRON_DNS_REFRESH"); v == "always" {
+			config.DNSRefresh = -1
+		} else if v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				validationError = fmt.Errorf("invalid CRON_DNS_REFRESH %q: must be \"always\" or a positive duration", v)
+			}
+			config.DNSRefresh = d
+		}
+		if v := src.get("CRON_ACCEPT_GZIP"); v != "" {
+			accept, err := strconv.ParseBool(v)
+			if err != nil {
+				validationError = fmt.Errorf("invalid CRON_ACCEPT_GZIP: %w", err)
+			}
+			config.AcceptGzip = &accept
+		}
+	case "shell":
+		config.ShellCommand = src.get("SHELL_COMMAND")
+		config.ShellTargetContainer = src.get("SHELL_TARGET_CONTAINER")
+		if targets := src.get("SHELL_TARGETS"); targets != "" {
+			parsed, err := parseShellTargets(targets)
+			if err != nil {
+				validationError = fmt.Errorf("invalid SHELL_TARGETS: %w", err)
+			}
+			config.ShellTargets = parsed
+			config.ShellTargetsParallel = src.getBool("SHELL_TARGETS_PARALLEL")
+			if config.ShellCommand != "" || config.ShellTargetContainer != "" {
+				validationError = errors.New("SHELL_TARGETS cannot be combined with SHELL_COMMAND or SHELL_TARGET_CONTAINER")
+			}
+		} else if config.ShellCommand == "" {
+			validationError = errors.New("SHELL_COMMAND is required")
+		}
+		config.ShellPreCommand = src.get("SHELL_PRE")
+		config.ShellPostCommand = src.get("SHELL_POST")
+		hooks := []struct{ key, command string }{
+			{"SHELL_PRE", config.ShellPreCommand},
+			{"SHELL_POST", config.ShellPostCommand},
+		}
+		config.ShellAllocatePTY = src.getBool("SHELL_ALLOCATE_PTY")
+		config.ShellEnv = parseShellEnv(src.get("SHELL_ENV"))
+		for _, env := range config.ShellEnv {
+			if name, _, ok := strings.Cut(env, "="); !ok || name == "" {
+				validationError = fmt.Errorf("invalid SHELL_ENV entry %q: must be KEY=VALUE", strings.SplitN(env, "=", 2)[0])
+			}
+		}
+		config.ShellExpandVars = src.getBool("SHELL_EXPAND_VARS")
+		if config.ShellExpandVars {
+			// Fail at startup rather than at the first run if a variable is undefined.
+			commands := []string{config.ShellCommand, config.ShellPreCommand, config.ShellPostCommand}
+			for _, target := range config.ShellTargets {
+				commands = append(commands, target.Command)
+			}
+			for _, command := range commands {
+				if _, err := expandVars(command, config.ShellEnv); err != nil {
+					validationError = fmt.Errorf("invalid SHELL_EXPAND_VARS command: %w", err)
+				}
+			}
+		}
+		if err := parseShellSettings(src, &config); err != nil {
+			validationError = err
+		}
+		if _, err := renderCommand(config, config.ShellCommand); err != nil {
+			validationError = fmt.Errorf("invalid SHELL_COMMAND: %w", err)
+		}
+		for _, target := range config.ShellTargets {
+			if _, err := renderCommand(config, target.Command); err != nil {
+				validationError = fmt.Errorf("SHELL_TARGETS container %q: invalid command: %w", target.Container, err)
+			}
+		}
+		allowlist := splitList(os.Getenv("SHELL_COMMAND_ALLOWLIST"))
+		if err := checkCommandAllowed(allowlist, config.ShellCommand); err != nil {
+			validationError = err
+		}
+		for _, hook := range hooks {
+			if hook.command == "" {
+				continue
+			}
+			if _, err := renderCommand(config, hook.command); err != nil {
+				validationError = fmt.Errorf("invalid %s: %w", hook.key, err)
+			}
+			if err := checkCommandAllowed(allowlist, hook.command); err != nil {
+				validationError = fmt.Errorf("%s: %w", hook.key, err)
+			}
+		}
+		for _, target := range config.ShellTargets {
+			if err := checkCommandAllowed(allowlist, target.Command); err != nil {
+				validationError = fmt.Errorf("SHELL_TARGETS container %q: %w", target.Container, err)
+			}
+		}
+		config.ShellDockerUser = src.get("SHELL_DOCKER_USER")
+		config.ShellDockerWorkdir = src.get("SHELL_DOCKER_WORKDIR")
+		for _, setting := range []struct{ key, value string }{
+			{"SHELL_DOCKER_USER", config.ShellDockerUser},
+			{"SHELL_DOCKER_WORKDIR", config.ShellDockerWorkdir},
+		} {
+			switch {
+			case setting.value == "":
+			case strings.TrimSpace(setting.value) == "":
+				validationError = fmt.Errorf("invalid %s: must not be blank", setting.key)
+			case !config.usesDockerExec():
+				validationError = fmt.Errorf("%s only applies to commands in a target container", setting.key)
+			}
+		}
+		if v := config.ShellDockerWorkdir; v != "" && !strings.HasPrefix(v, "/") {
+			validationError = fmt.Errorf("invalid SHELL_DOCKER_WORKDIR %q: must be an absolute path in the container", v)
+		}
+		if v := src.get("SHELL_WAIT_FOR_CONTAINER"); v != "" {
+			d, err := time.ParseDuration(v)
+			switch {
+			case err != nil || d <= 0:
+				validationError = fmt.Errorf("invalid SHELL_WAIT_FOR_CONTAINER %q: must be a positive duration", v)
+			case !config.usesDockerExec():
+				validationError = errors.New("SHELL_WAIT_FOR_CONTAINER only applies to commands in a target container")
+			}
+			config.ShellWaitForContainer = d
+		}
+		if config.usesDockerExec() && !dockerAvailable {
+			if envBool("DOCKER_FALLBACK_LOCAL") {
+				logger.Warn("Docker is not available, running remote shell command locally instead", "job_name", config.Name, "target_container", config.ShellTargetContainer)
+				config.ShellTargetContainer = ""
+				src.override("SHELL_TARGET_CONTAINER", "", "DOCKER_FALLBACK_LOCAL")
+				for t := range config.ShellTargets {
+					config.ShellTargets[t].Container = ""
+				}
+			} else {
+				validationError = errors.New("a target container is set but docker is not available (set DOCKER_FALLBACK_LOCAL=true to run locally)")
+			}
+		}
+		if v := src.get("SHELL_WORKDIR"); v != "" {
+			if config.usesDockerExec() {
+				// docker exec commands run in the container's own working directory.
+				validationError = errors.New("SHELL_WORKDIR only applies to local commands, not to a target container")
+			} else if info, err := os.Stat(v); err != nil || !info.IsDir() {
+				validationError = fmt.Errorf("invalid SHELL_WORKDIR %q: not an existing directory", v)
+			}
+			config.ShellWorkdir = v
+		}
+	case "docker_run":
+		config.ShellCommand = src.get("SHELL_COMMAND")
+		config.DockerImage = src.get("DOCKER_IMAGE")
+		config.DockerEnv = splitList(src.get("DOCKER_ENV"))
+		config.DockerVolumes = splitList(src.get("DOCKER_VOLUMES"))
+		config.DockerRemove = true
+		if v := src.get("DOCKER_RM"); v != "" {
+			remove, err := strconv.ParseBool(v)
+			if err != nil {
+				validationError = fmt.Errorf("invalid DOCKER_RM: %w", err)
+			}
+			config.DockerRemove = remove
+		} else {
+			src.setDefault("DOCKER_RM", "true")
+		}
+		if err := parseShellSettings(src, &config); err != nil {
+			validationError = err
+		}
+		if config.ShellCommand == "" {
+			validationError = errors.New("SHELL_COMMAND is required")
+		} else if _, err := renderCommand(config, config.ShellCommand); err != nil {
+			validationError = fmt.Errorf("invalid SHELL_COMMAND: %w", err)
+		}
+		if config.DockerImage == "" {
+			validationError = errors.New("DOCKER_IMAGE is required")
+		}
+		if !dockerAvailable {
+			validationError = errors.New("docker_run jobs need docker, which is not available")
+		}
+	case "cert_expiry":
+		config.CertHost = src.get("CERT_HOST")
+		if config.CertHost == "" {
+			validationError = errors.New("CERT_HOST is required")
+		} else if _, _, err := net.SplitHostPort(config.CertHost); err != nil {
+			// No port given: default to HTTPS.
+			config.CertHost = net.JoinHostPort(config.CertHost, "443")
+			if _, _, err := net.SplitHostPort(config.CertHost); err != nil {
+				validationError = fmt.Errorf("invalid CERT_HOST: %w", err)
+			}
+		}
+		config.CertWarnDays = 14
+		if v := src.get("CERT_WARN_DAYS"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				validationError = fmt.Errorf("invalid CERT_WARN_DAYS %q: must be a non-negative integer", v)
+			}
+			config.CertWarnDays = n
+		} else {
+			src.setDefault("CERT_WARN_DAYS", "14")
+		}
+	default:
+		validationError = errors.New("unknown JOB_TYPE: " + jobType)
+	}
+
+	if secretError != nil {
+		validationError = secretError
+	}
+	return config, validationError
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// newJSONJobSources parses CRON_JOBS_JSON, a JSON array with one object per job. The
+// object keys are the setting names without their index suffix, e.g.
+// {"CRON_SCHEDULE": "@hourly", "CRON_TARGET_URL": "..."}. Values may be strings,
+// numbers or booleans; arrays of strings are joined with newlines for list settings
+// such as SHELL_TARGETS.
+func newJSONJobSources(raw string) ([]*jobSource, error) {
+	jobs, err := decodeJSONJobs([]byte(raw))
+	if err != nil {
+		return nil, err
+	}
+	return newMapJobSources(jobs, "json:CRON_JOBS_JSON")
+}
+
+// newFileJobSources reads CONFIG_FILE, which holds the same array of job objects as
+// CRON_JOBS_JSON, as JSON or, for a .yaml or .yml file, as YAML.
+func newFileJobSources(path string) ([]*jobSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &jobs)
+	default:
+		jobs, err = decodeJSONJobs(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newMapJobSources(jobs, "file:"+path)
+}
+
+func decodeJSONJobs(data []byte) ([]map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var jobs []map[string]interface{}
+	if err := dec.Decode(&jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// newMapJobSources turns decoded job objects into job sources whose values are traced
+// as origin[i].KEY.
+func newMapJobSources(jobs []map[string]interface{}, origin string) ([]*jobSource, error) {
+	sources := make([]*jobSource, len(jobs))
+	for i, job := range jobs {
+		values := make(map[string]string, len(job))
+		for key, v := range job {
+			s, err := jsonSetting(v)
+			if err != nil {
+				return nil, fmt.Errorf("job %d: %s: %w", i, key, err)
+			}
+			values[key] = s
+		}
+		prefix := fmt.Sprintf("%s[%d].", origin, i)
+		sources[i] = &jobSource{
+			lookup: func(key string) (string, string) {
+				return values[key], prefix + key
+			},
+			sources: make(map[string]string),
+			values:  make(map[string]string),
+		}
+	}
+	return sources, nil
+}
+
+// jsonSetting converts a JSON (or YAML) value to the string form used by the env
+// variables.
+func jsonSetting(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case int, float64:
+		return fmt.Sprint(v), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	case []interface{}:
+		var b bytes.Buffer
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("list entries must be strings")
+			}
+			if i > 0 {
+				b.WriteByte('\n')
+			}
+			b.WriteString(s)
+		}
+		return b.String(), nil
+	}
+	return "", fmt.Errorf("unsupported value type %T", v)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+)
+
+// correlationHeader carries a run's correlation ID on outgoing HTTP requests so the
+// run can be traced end to end.
+const correlationHeader = "X-Correlation-Id"
+
+type correlationKey struct{}
+
+// newCorrelationID returns a random RFC 4122 version 4 UUID.
+func newCorrelationID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand does not fail on supported platforms; keep runs going regardless.
+		return "00000000-0000-4000-8000-000000000000"
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// maxCorrelationIDLength bounds a correlation ID supplied by a caller.
+const maxCorrelationIDLength = 128
+
+// validCorrelationID reports whether a caller-supplied correlation ID can be used
+// as is: printable ASCII without spaces, so it is safe in log lines and headers.
+func validCorrelationID(id string) bool {
+	if id == "" || len(id) > maxCorrelationIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// withCorrelationID returns a context carrying the run's correlation ID.
+func withCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// correlationID returns the correlation ID stored in ctx, or "" if there is none.
+func correlationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
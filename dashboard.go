@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/subtle"
+	"embed"
+	"net/http"
+	"strings"
+)
+
+// dashboardFS holds the status page served at GET /. It is self-contained, without
+// external scripts or styles, so it also works in air-gapped deployments.
+//
+//go:embed dashboard.html
+var dashboardFS embed.FS
+
+// dashboardHandler serves the status page, which renders GET /jobs.
+func dashboardHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// "/" matches every path the mux doesn't know otherwise.
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		page, err := dashboardFS.ReadFile("dashboard.html")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page)
+	})
+}
+
+// requireToken protects h with DASHBOARD_TOKEN, supplied as ?token= (for opening the
+// page in a browser) or as a bearer token. Without a token, h is returned as is.
+func requireToken(token string, h http.Handler) http.Handler {
+	if token == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		supplied := r.URL.Query().Get("token")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			supplied = bearer
+		}
+		if subtle.ConstantTimeCompare([]byte(supplied), []byte(token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDashboardToken(t *testing.T) {
+	t.Setenv("DASHBOARD_TOKEN", "s3cret")
+
+	tests := []struct {
+		method, target, auth string
+		want                 int
+	}{
+		{http.MethodGet, "/healthz", "", http.StatusOK},
+		{http.MethodGet, "/", "", http.StatusUnauthorized},
+		{http.MethodGet, "/?token=wrong", "", http.StatusUnauthorized},
+		{http.MethodGet, "/?token=s3cret", "", http.StatusOK},
+		{http.MethodGet, "/jobs", "", http.StatusUnauthorized},
+		{http.MethodGet, "/jobs", "Bearer s3cret", http.StatusOK},
+		{http.MethodPost, "/resume", "", http.StatusUnauthorized},
+		{http.MethodPost, "/resume", "Bearer wrong", http.StatusUnauthorized},
+		{http.MethodPost, "/resume", "Bearer s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		gate := newPauseGate(true)
+		mux := healthCheckMux(discardLog, gate, &jobDirectory{stats: newRunStats()})
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s (Authorization %q): status = %d, want %d", tt.method, tt.target, tt.auth, rec.Code, tt.want)
+		}
+		if tt.target == "/resume" && gate.paused() != (tt.want != http.StatusOK) {
+			t.Errorf("%s %s (Authorization %q): paused = %v after the request", tt.method, tt.target, tt.auth, gate.paused())
+		}
+	}
+}
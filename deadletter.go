@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// deadLetterEntry is one permanently failed run, with enough context to replay it by hand.
+type deadLetterEntry struct {
+	Time          time.Time     `json:"time"`
+	CorrelationID string        `json:"correlation_id,omitempty"`
+	Job           string        `json:"job"`
+	Type          string        `json:"type"`
+	StartedAt     time.Time     `json:"started_at"`
+	Error         string        `json:"error"`
+	Config        deadLetterJob `json:"config"`
+}
+
+// deadLetterJob is the part of the job's configuration a dead-letter entry carries:
+// what the run executed, with credentials and SHELL_REDACT_PATTERNS matches masked,
+// and on which schedule.
+type deadLetterJob struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Target   string `json:"target"`
+	Schedule string `json:"schedule"`
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// deadLetterLog appends permanently failed runs as JSON lines to DEAD_LETTER_FILE.
+// A nil *deadLetterLog is valid and records nothing.
+type deadLetterLog struct {
+	path   string
+	logger *slog.Logger
+	mu     sync.Mutex
+}
+
+func newDeadLetterLogFromEnv(logger *slog.Logger) *deadLetterLog {
+	path := os.Getenv("DEAD_LETTER_FILE")
+	if path == "" {
+		return nil
+	}
+	logger.Info("Dead-letter log enabled", "path", path)
+	return &deadLetterLog{path: path, logger: logger}
+}
+
+// Record appends a failed run of config to the dead-letter file.
+func (d *deadLetterLog) Record(config Config, correlationID string, startedAt time.Time, runErr error) {
+	if d == nil {
+		return
+	}
+
+	line, err := json.Marshal(deadLetterEntry{
+		Time:          time.Now(),
+		CorrelationID: correlationID,
+		Job:           config.Name,
+		Type:          config.JobType,
+		StartedAt:     startedAt,
+		Error:         runErr.Error(),
+		Config: deadLetterJob{
+			Name:     config.Name,
+			Type:     config.JobType,
+			Target:   auditTarget(config),
+			Schedule: config.Schedule,
+			Timezone: config.Timezone,
+		},
+	})
+	if err != nil {
+		d.logger.Error("Failed to encode dead-letter entry", "job_name", config.Name, "error", err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, err := os.OpenFile(d.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		d.logger.Error("Failed to open dead-letter file", "path", d.path, "error", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		d.logger.Error("Failed to write dead-letter entry", "path", d.path, "error", err)
+	}
+}
+
+// prune rewrites the dead-letter file without entries older than maxAge and, when
+// maxEntries > 0, keeps only the newest maxEntries. Lines that can't be parsed are kept.
+func (d *deadLetterLog) prune(maxAge time.Duration, maxEntries int) (removed, kept int, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	data, err := os.ReadFile(d.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	var lines [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var entry struct {
+			Time time.Time `json:"time"`
+		}
+		if maxAge > 0 && json.Unmarshal(line, &entry) == nil && entry.Time.Before(cutoff) {
+			removed++
+			continue
+		}
+		lines = append(lines, append([]byte(nil), line...))
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	if maxEntries > 0 && len(lines) > maxEntries {
+		removed += len(lines) - maxEntries
+		lines = lines[len(lines)-maxEntries:]
+	}
+	if removed == 0 {
+		return 0, len(lines), nil
+	}
+
+	var out bytes.Buffer
+	for _, line := range lines {
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	return removed, len(lines), writeBytesAtomic(d.path, out.Bytes())
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestDeadLetterEntryDescribesJob(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	d := &deadLetterLog{path: path, logger: discardLog}
+	d.Record(Config{
+		Name:                 "dump",
+		JobType:              "shell",
+		Schedule:             "0 3 * * *",
+		Timezone:             "Europe/Berlin",
+		ShellCommand:         "pg_dump postgres://app:hunter2@db/app",
+		ShellTargetContainer: "db",
+		ShellRedactPatterns:  []*regexp.Regexp{regexp.MustCompile(`hunter2`)},
+	}, "run-1", time.Now(), errors.New("exit status 1"))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry struct {
+		Config map[string]interface{} `json:"config"`
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"name":     "dump",
+		"type":     "shell",
+		"schedule": "0 3 * * *",
+		"timezone": "Europe/Berlin",
+		"target":   "db: pg_dump postgres://app:***@db/app",
+	}
+	if len(entry.Config) != len(want) {
+		t.Errorf("config = %v, want %v", entry.Config, want)
+	}
+	for key, v := range want {
+		if entry.Config[key] != v {
+			t.Errorf("config.%s = %v, want %v", key, entry.Config[key], v)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// dockerAvailable is set once at startup and reports whether docker exec jobs can run.
+var dockerAvailable bool
+
+// dockerSocketPath returns the path of the Docker daemon socket, honouring a
+// unix:// DOCKER_HOST. It returns an empty string when the daemon is reached over TCP.
+func dockerSocketPath() string {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		return "/var/run/docker.sock"
+	}
+	if strings.HasPrefix(host, "unix://") {
+		return strings.TrimPrefix(host, "unix://")
+	}
+	return ""
+}
+
+// detectDocker checks that the docker CLI is on the PATH and that the daemon socket
+// is mounted, logging the result so misconfigured containers are obvious at startup.
+func detectDocker(logger *slog.Logger) bool {
+	cliPath, err := exec.LookPath("docker")
+	if err != nil {
+		logger.Warn("Docker capability check: docker CLI not found, docker exec jobs are unavailable", "error", err)
+		return false
+	}
+
+	socket := dockerSocketPath()
+	if socket != "" {
+		if _, err := os.Stat(socket); err != nil {
+			logger.Warn("Docker capability check: docker socket not accessible, docker exec jobs are unavailable", "docker_cli", cliPath, "socket", socket, "error", err)
+			return false
+		}
+	}
+
+	logger.Info("Docker capability check passed", "docker_cli", cliPath, "socket", socket, "docker_host", os.Getenv("DOCKER_HOST"))
+	return true
+}
+
+// dockerExecSlots bounds the number of docker exec processes running at once so that
+// simultaneous jobs don't overwhelm the Docker daemon. A nil semaphore means no limit.
+var dockerExecSlots semaphore
+
+// containerHealthPollInterval is how often WAIT_FOR_CONTAINER_HEALTH re-inspects a
+// container that is not healthy yet.
+const containerHealthPollInterval = 2 * time.Second
+
+// containerHealth returns the health status docker reports for container ("healthy",
+// "starting", "unhealthy"), or its state ("running", "exited", ...) when the container
+// has no health check.
+func containerHealth(ctx context.Context, container string) (string, error) {
+	out, err := exec.CommandContext(ctx, "docker", "inspect", "--format",
+		"{{if .State.Health}}{{.State.Health.Status}}{{else}}{{.State.Status}}{{end}}", container).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// waitForContainers implements WAIT_FOR_CONTAINER_HEALTH: before the scheduler starts,
+// it waits up to timeout for every container targeted by docker exec jobs to be
+// healthy (or running, if it has no health check). Containers that don't get there in
+// time are logged, and the runner starts anyway.
+func waitForContainers(logger *slog.Logger, configs []Config, timeout time.Duration) {
+	if timeout <= 0 || !dockerAvailable {
+		return
+	}
+
+	var containers []string
+	seen := make(map[string]bool)
+	for _, config := range configs {
+		names := []string{config.ShellTargetContainer}
+		for _, t := range config.ShellTargets {
+			names = append(names, t.Container)
+		}
+		for _, name := range names {
+			if name != "" && !seen[name] {
+				seen[name] = true
+				containers = append(containers, name)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, container := range containers {
+		waitForContainer(ctx, logger, container, timeout)
+	}
+}
+
+// waitForContainer polls container until it is ready or ctx expires.
+func waitForContainer(ctx context.Context, logger *slog.Logger, container string, timeout time.Duration) {
+	logger.Info("Waiting for container to become healthy", "target_container", container, "timeout", timeout.String())
+	started := time.Now()
+	for {
+		status, err := containerHealth(ctx, container)
+		if err == nil && (status == "healthy" || status == "running") {
+			logger.Info("Container is ready", "target_container", container, "health", status, "waited", time.Since(started).Round(time.Millisecond).String())
+			return
+		}
+		select {
+		case <-ctx.Done():
+			logger.Warn("Gave up waiting for container health, starting anyway", "target_container", container, "health", status, "error", err)
+			return
+		case <-time.After(containerHealthPollInterval):
+		}
+	}
+}
+
+// containerNotRunningMarkers are what docker exec writes to stderr when the target
+// container is stopped or being restarted.
+var containerNotRunningMarkers = []string{"is not running", "is restarting"}
+
+// containerNotRunning reports whether the stderr of a failed docker exec says that the
+// target container is not running.
+func containerNotRunning(stderr string) bool {
+	for _, marker := range containerNotRunningMarkers {
+		if strings.Contains(stderr, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForContainerRunning implements SHELL_WAIT_FOR_CONTAINER: it polls container until
+// docker inspect reports it running (and not restarting), and fails if that takes
+// longer than timeout or ctx ends first.
+func waitForContainerRunning(ctx context.Context, container string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		out, err := exec.CommandContext(ctx, "docker", "inspect", "--format",
+			"{{.State.Running}} {{.State.Restarting}}", container).Output()
+		if err == nil && strings.TrimSpace(string(out)) == "true false" {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return fmt.Errorf("container is still not running after %s: %w", timeout, err)
+			}
+			return fmt.Errorf("container is still not running after %s", timeout)
+		case <-time.After(containerHealthPollInterval):
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// dockerRunArgs returns the docker CLI arguments that run the job's command in a fresh
+// container. When masked is true, environment values are replaced with "***" so the
+// result can be logged.
+func dockerRunArgs(config Config, masked bool) []string {
+	args := []string{"docker", "run"}
+	if config.DockerRemove {
+		args = append(args, "--rm")
+	}
+	for _, env := range config.DockerEnv {
+		if name, _, ok := strings.Cut(env, "="); ok && masked {
+			env = name + "=***"
+		}
+		args = append(args, "-e", env)
+	}
+	for _, volume := range config.DockerVolumes {
+		args = append(args, "-v", volume)
+	}
+	return append(args, config.DockerImage, config.ShellInterpreter, "-c", config.ShellCommand)
+}
+
+// runDockerRun runs the job's command in a new container from DockerImage. Its output
+// and exit code are handled like those of a shell job.
+func runDockerRun(ctx context.Context, log *slog.Logger, config Config) error {
+	command, err := renderCommand(config, config.ShellCommand)
+	if err != nil {
+		log.Error("Failed to render the shell command", "error", err)
+		return err
+	}
+	config.ShellCommand = command
+	log.Info("Executing shell command in a new container via docker run", "command", config.ShellCommand, "image", config.DockerImage)
+
+	release, err := acquireDockerExecSlot(ctx, log)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	args := dockerRunArgs(config, false)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	// On timeout, SIGTERM lets the docker CLI forward the signal to the container
+	// (killing the CLI would leave the container running); SIGKILL follows if needed.
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = 10 * time.Second
+	log.Debug("Resolved shell invocation", "command_line", redact(quoteArgs(dockerRunArgs(config, true)), config.ShellRedactPatterns))
+	return runLogged(ctx, log, config, cmd)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// dryRunNextRuns is how many upcoming run times DRY_RUN lists per job.
+const dryRunNextRuns = 3
+
+// dryRun implements DRY_RUN: it loads the configuration and logs every job with its
+// next run times, without running anything. It returns the process exit code,
+// non-zero if any job is invalid.
+func dryRun(logger *slog.Logger) int {
+	logger.Info("Dry run, no jobs will be executed")
+	dockerAvailable = detectDocker(logger)
+	configs, invalid := loadConfigs(logger)
+	if !resolveDuplicateNames(logger, configs, envBool("STRICT_CONFIG")) {
+		invalid++
+	}
+
+	horizon := envDuration(logger, "SCHEDULE_HORIZON", 366*24*time.Hour)
+	for _, config := range configs {
+		if config.Schedule == scheduleAtStartup {
+			logger.Info("Dry run job", "job_name", config.Name, "schedule", config.Schedule, "type", config.JobType, "next_runs", "once, when the scheduler starts")
+			continue
+		}
+		schedule, err := parseSchedule(config)
+		if err != nil {
+			logger.Error("Invalid job schedule", "job_name", config.Name, "schedule", config.Schedule, "error", err)
+			invalid++
+			continue
+		}
+		if warnIfScheduleNeverFires(logger, config, schedule, horizon) && envBool("STRICT_CONFIG") {
+			invalid++
+		}
+		logger.Info("Dry run job", "job_name", config.Name, "schedule", config.Schedule, "type", config.JobType, "next_runs", nextRuns(config, schedule, time.Now(), dryRunNextRuns))
+	}
+
+	fields := []interface{}{"jobs", len(configs), "invalid_jobs", invalid}
+	if invalid > 0 {
+		logger.Error("Dry run found invalid jobs", fields...)
+		return 1
+	}
+	logger.Info("Dry run complete", fields...)
+	return 0
+}
+
+// nextRuns returns up to n run times of config after now, including a delayed
+// CRON_START_AFTER first run. It is shorter for schedules that stop firing.
+func nextRuns(config Config, schedule cron.Schedule, now time.Time, n int) []time.Time {
+	var runs []time.Time
+	next := schedule.Next(now)
+	if config.StartAfter > 0 {
+		next = processStart.Add(config.StartAfter)
+		runs = append(runs, next)
+		next = schedule.Next(next)
+	}
+	for len(runs) < n && !next.IsZero() {
+		runs = append(runs, next)
+		next = schedule.Next(next)
+	}
+	return runs
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventSchemaVersion is bumped whenever the event format changes incompatibly.
+const eventSchemaVersion = 1
+
+// Lifecycle event names written to the EVENT_STREAM.
+const (
+	eventJobStarted       = "job_started"
+	eventJobSucceeded     = "job_succeeded"
+	eventJobFailed        = "job_failed"
+	eventJobSkipped       = "job_skipped"
+	eventSchedulerStarted = "scheduler_started"
+	eventShutdown         = "shutdown"
+)
+
+// event is one line of the EVENT_STREAM. Unlike the logs, its fields are a stable
+// interface for programs that react to the runner.
+type event struct {
+	Version       int       `json:"version"`
+	Time          time.Time `json:"time"`
+	Event         string    `json:"event"`
+	Job           string    `json:"job,omitempty"`
+	Type          string    `json:"type,omitempty"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	DurationMs    *int64    `json:"duration_ms,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	Reason        string    `json:"reason,omitempty"`
+	JobCount      *int      `json:"job_count,omitempty"`
+}
+
+// eventStream writes lifecycle events as newline-delimited JSON. A nil *eventStream is
+// valid and writes nothing.
+type eventStream struct {
+	mu     sync.Mutex
+	w      io.Writer
+	logger *slog.Logger
+}
+
+// newEventStreamFromEnv opens EVENT_STREAM, which is "stdout", "stderr", "fd:N" for an
+// inherited file descriptor, or the path of a file to append to.
+func newEventStreamFromEnv(logger *slog.Logger) *eventStream {
+	target := os.Getenv("EVENT_STREAM")
+	if target == "" {
+		return nil
+	}
+
+	var w io.Writer
+	switch {
+	case target == "stdout":
+		w = os.Stdout
+	case target == "stderr":
+		w = os.Stderr
+	case strings.HasPrefix(target, "fd:"):
+		fd, err := strconv.Atoi(strings.TrimPrefix(target, "fd:"))
+		if err != nil || fd < 0 {
+			logger.Error("Invalid EVENT_STREAM file descriptor, event stream disabled", "event_stream", target)
+			return nil
+		}
+		w = os.NewFile(uintptr(fd), target)
+	default:
+		f, err := os.OpenFile(target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			logger.Error("Failed to open EVENT_STREAM, event stream disabled", "event_stream", target, "error", err)
+			return nil
+		}
+		w = f
+	}
+	logger.Info("Event stream enabled", "event_stream", target, "schema_version", eventSchemaVersion)
+	return &eventStream{w: w, logger: logger}
+}
+
+// emit writes e, filling in its version and time.
+func (s *eventStream) emit(e event) {
+	if s == nil {
+		return
+	}
+	e.Version = eventSchemaVersion
+	e.Time = time.Now()
+	line, err := json.Marshal(e)
+	if err != nil {
+		s.logger.Error("Failed to encode event", "event", e.Event, "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		s.logger.Error("Failed to write event", "event", e.Event, "error", err)
+	}
+}
+
+// jobEvent returns the event of a run of config.
+func jobEvent(name string, config Config, correlationID string) event {
+	return event{Event: name, Job: config.Name, Type: config.JobType, CorrelationID: correlationID}
+}
+
+// jobFinishedEvent returns the job_succeeded or job_failed event of a completed run.
+func jobFinishedEvent(config Config, correlationID string, startedAt time.Time, err error) event {
+	e := jobEvent(eventJobSucceeded, config, correlationID)
+	ms := time.Since(startedAt).Milliseconds()
+	e.DurationMs = &ms
+	if err != nil {
+		e.Event = eventJobFailed
+		e.Error = err.Error()
+	}
+	return e
+}
+
+// jobSkippedEvent returns the job_skipped event of a run that did not execute.
+func jobSkippedEvent(config Config, correlationID string, reason error) event {
+	e := jobEvent(eventJobSkipped, config, correlationID)
+	e.Reason = fmt.Sprint(reason)
+	return e
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// goroutineCheckInterval is how often MAX_GOROUTINES is checked.
+const goroutineCheckInterval = 5 * time.Second
+
+// errGoroutineLimit is the skip reason of runs shed by MAX_GOROUTINES_SHED.
+var errGoroutineLimit = errors.New("goroutine limit reached")
+
+// goroutineGuard implements MAX_GOROUTINES, a safety valve against runaway resource
+// use: it warns once the goroutine count reaches 90% of the limit and, with shedding
+// enabled, skips new runs while the count is at or above the limit.
+// A nil *goroutineGuard is valid and never sheds.
+type goroutineGuard struct {
+	limit  int
+	shed   bool
+	logger *slog.Logger
+	over   atomic.Bool
+}
+
+func newGoroutineGuardFromEnv(logger *slog.Logger) *goroutineGuard {
+	limit := envInt(logger, "MAX_GOROUTINES", 0)
+	if limit <= 0 {
+		return nil
+	}
+	g := &goroutineGuard{limit: limit, shed: envBool("MAX_GOROUTINES_SHED"), logger: logger}
+	logger.Info("Goroutine limit enabled", "max_goroutines", limit, "shed", g.shed)
+	go g.watch()
+	return g
+}
+
+// watch samples the goroutine count and logs when it crosses the warning level.
+func (g *goroutineGuard) watch() {
+	warn := g.limit * 9 / 10
+	warned := false
+	for range time.Tick(goroutineCheckInterval) {
+		n := runtime.NumGoroutine()
+		g.over.Store(n >= g.limit)
+		switch {
+		case n >= warn && !warned:
+			warned = true
+			g.logger.Warn("Goroutine count is approaching MAX_GOROUTINES", "goroutines", n, "max_goroutines", g.limit, "shedding", g.shed)
+		case n < warn && warned:
+			warned = false
+			g.logger.Info("Goroutine count is back below the warning level", "goroutines", n, "max_goroutines", g.limit)
+		}
+	}
+}
+
+// shedding reports whether new runs should be skipped.
+func (g *goroutineGuard) shedding() bool {
+	return g != nil && g.shed && g.over.Load()
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// outcomeHistory is a fixed-size ring buffer of a job's most recent run outcomes.
+type outcomeHistory struct {
+	mu       sync.Mutex
+	outcomes []bool // true = success
+	next     int
+	full     bool
+}
+
+func newOutcomeHistory(size int) *outcomeHistory {
+	return &outcomeHistory{outcomes: make([]bool, size)}
+}
+
+// add records an outcome and returns the buffered outcomes, oldest first.
+func (h *outcomeHistory) add(success bool) []bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.outcomes[h.next] = success
+	h.next = (h.next + 1) % len(h.outcomes)
+	if h.next == 0 {
+		h.full = true
+	}
+	return h.snapshotLocked()
+}
+
+// last returns the most recent outcome; ok is false while the history is empty.
+func (h *outcomeHistory) last() (success, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.full && h.next == 0 {
+		return false, false
+	}
+	return h.outcomes[(h.next-1+len(h.outcomes))%len(h.outcomes)], true
+}
+
+func (h *outcomeHistory) snapshotLocked() []bool {
+	if !h.full {
+		return append([]bool(nil), h.outcomes[:h.next]...)
+	}
+	return append(append([]bool(nil), h.outcomes[h.next:]...), h.outcomes[:h.next]...)
+}
+
+// flapScore is the fraction of consecutive outcome pairs that changed state: 0 for a
+// steady job, 1 for one that alternates success and failure on every run.
+func flapScore(outcomes []bool) float64 {
+	if len(outcomes) < 2 {
+		return 0
+	}
+	transitions := 0
+	for i := 1; i < len(outcomes); i++ {
+		if outcomes[i] != outcomes[i-1] {
+			transitions++
+		}
+	}
+	return float64(transitions) / float64(len(outcomes)-1)
+}
+
+// flapDetector watches a job's outcome history and reports when it starts or stops
+// flapping between success and failure.
+type flapDetector struct {
+	threshold float64
+	window    int
+	history   *outcomeHistory
+
+	mu       sync.Mutex
+	flapping bool
+	score    float64 // of the latest judged run
+}
+
+// newFlapDetector returns nil when flap detection is disabled (threshold <= 0).
+func newFlapDetector(threshold float64, window int) *flapDetector {
+	if threshold <= 0 {
+		return nil
+	}
+	return &flapDetector{threshold: threshold, window: window, history: newOutcomeHistory(window)}
+}
+
+// observe records an outcome. changed is true when the flapping state flipped with this
+// run, so callers warn once rather than on every run.
+func (d *flapDetector) observe(success bool) (flapping, changed bool, score float64) {
+	if d == nil {
+		return false, false, 0
+	}
+	outcomes := d.history.add(success)
+	if len(outcomes) < d.window {
+		// Not enough history yet to judge.
+		return false, false, 0
+	}
+	score = flapScore(outcomes)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := score >= d.threshold
+	changed = now != d.flapping
+	d.flapping, d.score = now, score
+	return now, changed, score
+}
+
+// state returns whether the job is flapping and its latest flap score, for GET /jobs.
+func (d *flapDetector) state() (flapping bool, score float64) {
+	if d == nil {
+		return false, 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.flapping, d.score
+}
+
+// transientVerdict classifies a run for CRON_TRANSIENT_FAILURES jobs.
+type transientVerdict int
+
+const (
+	verdictNone      transientVerdict = iota
+	verdictTransient                  // failed right after a success; treated as a blip until the next run
+	verdictSustained                  // failed again after a failure that was treated as a blip
+	verdictIsolated                   // succeeded after a blip, confirming it was isolated
+)
+
+// transientFilter downgrades a failure that immediately follows a success, so only
+// sustained failures alert. Whether it really was isolated is known one run later.
+type transientFilter struct {
+	history *outcomeHistory
+}
+
+// newTransientFilter returns nil when the feature is disabled for the job.
+func newTransientFilter(enabled bool) *transientFilter {
+	if !enabled {
+		return nil
+	}
+	return &transientFilter{history: newOutcomeHistory(3)}
+}
+
+// lenient reports whether a failure of the upcoming run should be treated as
+// transient, i.e. whether the previous run succeeded.
+func (f *transientFilter) lenient() bool {
+	if f == nil {
+		return false
+	}
+	success, ok := f.history.last()
+	return ok && success
+}
+
+// observe records the outcome of a run and classifies it against the runs before it.
+func (f *transientFilter) observe(success bool) transientVerdict {
+	if f == nil {
+		return verdictNone
+	}
+	outcomes := f.history.add(success)
+	n := len(outcomes)
+	switch {
+	case n >= 2 && !success && outcomes[n-2]:
+		return verdictTransient
+	case n >= 3 && !outcomes[n-2] && outcomes[n-3]:
+		if success {
+			return verdictIsolated
+		}
+		return verdictSustained
+	}
+	return verdictNone
+}
+
+// errJobDisabled is the skip reason of runs of a job its failure breaker disabled.
+var errJobDisabled = errors.New("job disabled after too many consecutive failures")
+
+// failureBreaker disables a job once CRON_MAX_CONSECUTIVE_FAILURES runs in a row have
+// failed. A success resets the count. It stays tripped for the life of the job.
+type failureBreaker struct {
+	threshold int
+
+	mu         sync.Mutex
+	failures   int
+	disabledAt time.Time
+	reminder   *time.Ticker
+	done       chan struct{}
+}
+
+// newFailureBreaker returns nil when the breaker is disabled (threshold <= 0).
+func newFailureBreaker(threshold int) *failureBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &failureBreaker{threshold: threshold}
+}
+
+// observe records an outcome. tripped is true for the failure that disables the job,
+// so callers take it off the schedule once.
+func (b *failureBreaker) observe(success bool) (tripped bool, failures int) {
+	if b == nil {
+		return false, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.disabledAt.IsZero() {
+		return false, b.failures
+	}
+	if success {
+		b.failures = 0
+		return false, 0
+	}
+	b.failures++
+	if b.failures < b.threshold {
+		return false, b.failures
+	}
+	b.disabledAt = time.Now()
+	return true, b.failures
+}
+
+// observeRun is observe for the outcome of a completed run. Failures during
+// STARTUP_GRACE don't count towards the threshold, and don't reset the count either.
+func (b *failureBreaker) observeRun(rec runRecord) (tripped bool, failures int) {
+	if !rec.Success && rec.StartupGrace {
+		if b == nil {
+			return false, 0
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return false, b.failures
+	}
+	return b.observe(rec.Success)
+}
+
+// disabled reports whether the breaker has tripped.
+func (b *failureBreaker) disabled() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.disabledAt.IsZero()
+}
+
+// remind logs a warning every interval for as long as the job stays disabled, so it
+// isn't forgotten. It does nothing for interval <= 0.
+func (b *failureBreaker) remind(log *slog.Logger, every time.Duration) {
+	if b == nil || every <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.reminder != nil {
+		return
+	}
+	b.reminder, b.done = time.NewTicker(every), make(chan struct{})
+	go func(ticks <-chan time.Time, done <-chan struct{}, since time.Time) {
+		for {
+			select {
+			case <-ticks:
+				log.Warn("Job is still disabled after too many consecutive failures", "disabled_since", since, "disabled_for", time.Since(since).Round(time.Second).String())
+			case <-done:
+				return
+			}
+		}
+	}(b.reminder.C, b.done, b.disabledAt)
+}
+
+// stop ends the reminders, once the job is removed or replaced.
+func (b *failureBreaker) stop() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.reminder != nil {
+		b.reminder.Stop()
+		close(b.done)
+		b.reminder = nil
+	}
+}
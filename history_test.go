@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestFailureBreakerIgnoresStartupGraceFailures(t *testing.T) {
+	b := newFailureBreaker(2)
+	outcomes := []struct {
+		rec         runRecord
+		wantTripped bool
+		wantCount   int
+	}{
+		{rec: runRecord{Success: false, StartupGrace: true}, wantCount: 0},
+		{rec: runRecord{Success: false, StartupGrace: true}, wantCount: 0},
+		{rec: runRecord{Success: false, StartupGrace: true}, wantCount: 0},
+		{rec: runRecord{Success: false}, wantCount: 1},
+		{rec: runRecord{Success: false, StartupGrace: true}, wantCount: 1},
+		{rec: runRecord{Success: false}, wantTripped: true, wantCount: 2},
+	}
+	for i, o := range outcomes {
+		tripped, failures := b.observeRun(o.rec)
+		if tripped != o.wantTripped || failures != o.wantCount {
+			t.Errorf("run %d: observeRun = (%v, %d), want (%v, %d)", i+1, tripped, failures, o.wantTripped, o.wantCount)
+		}
+	}
+	if !b.disabled() {
+		t.Error("breaker is not disabled after two failures outside the startup grace period")
+	}
+}
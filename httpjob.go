@@ -0,0 +1,632 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultUserAgent is the User-Agent of http jobs without CRON_USER_AGENT or
+// HTTP_USER_AGENT, so their requests can be told apart from other Go clients.
+var defaultUserAgent = "easypanel-cron/" + buildVersion()
+
+// buildVersion returns the module version the runner was built from, or "dev" for
+// local builds.
+func buildVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// preflightTimeout bounds the DNS lookup and TCP connect of a preflight check of a job
+// without CRON_DIAL_TIMEOUT.
+const preflightTimeout = 5 * time.Second
+
+// preflight resolves the target URL's host and opens (and closes) a TCP connection to
+// it, so DNS and connectivity failures are reported distinctly from HTTP errors. It
+// dials like the job's requests, and gives up when ctx ends.
+func preflight(ctx context.Context, config Config, target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("preflight: invalid target URL: %w", err)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+
+	dialer := jobDialer(config)
+	if config.DialTimeout == 0 {
+		dialer.Timeout = preflightTimeout
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			return fmt.Errorf("preflight: DNS lookup for %s failed: %w", u.Hostname(), err)
+		}
+		return fmt.Errorf("preflight: TCP connect to %s failed: %w", addr, err)
+	}
+	conn.Close()
+	return nil
+}
+
+// CRON_AUTH_TYPE values.
+const (
+	authBearer = "bearer"
+	authBasic  = "basic"
+	authHeader = "header"
+	authNone   = "none"
+)
+
+// setAuth adds the job's credentials to req according to CRON_AUTH_TYPE. With
+// CRON_AWS_SIGV4 the signature takes the place of the bearer token.
+func setAuth(req *http.Request, config Config) {
+	switch config.AuthType {
+	case authBearer:
+		if !config.AWSSigV4 {
+			req.Header.Set("Authorization", "Bearer "+config.SecretToken)
+		}
+	case authBasic:
+		req.SetBasicAuth(config.AuthUser, config.AuthPass)
+	case authHeader:
+		req.Header.Set(config.AuthHeader, config.SecretToken)
+	}
+}
+
+// defaultHMACHeader is the default CRON_HMAC_HEADER.
+const defaultHMACHeader = "X-Signature-256"
+
+// signBody sets the CRON_HMAC_SECRET signature of the request body, the hex-encoded
+// HMAC-SHA256 of body, on req. Requests without a body are not signed.
+func signBody(req *http.Request, config Config) {
+	if config.HMACSecret == "" || config.HTTPBody == "" {
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(config.HMACSecret))
+	mac.Write([]byte(config.HTTPBody))
+	req.Header.Set(config.HMACHeader, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// parseTargetURLs splits a CRON_TARGET_URL list such as "https://a/ping,https://b/ping".
+// A comma only separates URLs when an http:// or https:// URL follows it, so a single
+// URL with a comma in its query is kept as it is.
+func parseTargetURLs(v string) []string {
+	var urls []string
+	for _, part := range strings.Split(v, ",") {
+		trimmed := strings.TrimSpace(part)
+		lower := strings.ToLower(trimmed)
+		if len(urls) > 0 && !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+			urls[len(urls)-1] += "," + part
+			continue
+		}
+		urls = append(urls, trimmed)
+	}
+	if len(urls) == 1 {
+		// A single URL is used exactly as configured.
+		return []string{v}
+	}
+	return urls
+}
+
+// targetResult is the outcome of one target of a fan-out run.
+type targetResult struct {
+	URL        string `json:"url"`
+	Status     string `json:"status"` // "success", "failure" or "timeout"
+	StatusCode int    `json:"status_code,omitempty"`
+	Duration   string `json:"duration"`
+}
+
+// fanOut requests every target concurrently, runs[i] being the request to urls[i].
+// The run fails if all targets fail or, with CRON_FANOUT_REQUIRE_ALL, if any does;
+// with BATCH_SUCCESS_THRESHOLD, if fewer than that share of them succeed. Each
+// target's outcome is logged separately, and all of them together once the run is
+// done. BATCH_URL_TIMEOUT cuts a slow target short.
+func fanOut(config Config, urls []string, runs []func(ctx context.Context, log *slog.Logger) error) func(ctx context.Context, log *slog.Logger) error {
+	return func(ctx context.Context, log *slog.Logger) error {
+		errs := make([]error, len(runs))
+		results := make([]targetResult, len(runs))
+		var wg sync.WaitGroup
+		for i := range runs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				tlog := log.With("target_index", i+1)
+				tctx := ctx
+				if config.BatchURLTimeout > 0 {
+					var cancel context.CancelFunc
+					tctx, cancel = context.WithTimeout(ctx, config.BatchURLTimeout)
+					defer cancel()
+				}
+				started := time.Now()
+				err := runs[i](tctx, tlog)
+				results[i] = targetResult{URL: redactURL(urls[i]), Status: "success", Duration: time.Since(started).Round(time.Millisecond).String()}
+				if err != nil {
+					results[i].Status = "failure"
+					var status *statusError
+					if errors.As(err, &status) {
+						results[i].StatusCode = status.code
+					}
+					if ctx.Err() == nil && errors.Is(tctx.Err(), context.DeadlineExceeded) {
+						results[i].Status = "timeout"
+						err = fmt.Errorf("exceeded BATCH_URL_TIMEOUT %s: %w", config.BatchURLTimeout, err)
+					}
+					errs[i] = fmt.Errorf("target %d (%s): %w", i+1, redactURL(urls[i]), err)
+					tlog.Error("Target failed", "target_url", redactURL(urls[i]), "duration", results[i].Duration, "error", err)
+					return
+				}
+				tlog.Info("Target completed successfully", "target_url", redactURL(urls[i]), "duration", results[i].Duration)
+			}(i)
+		}
+		wg.Wait()
+
+		failed := 0
+		for _, err := range errs {
+			if err != nil {
+				failed++
+			}
+		}
+		log.Info("Fan-out results", "results", results)
+		succeeded := failed == 0 || (failed < len(runs) && !config.FanoutRequireAll)
+		if config.BatchSuccessThreshold > 0 {
+			succeeded = float64(len(runs)-failed)*100 >= config.BatchSuccessThreshold*float64(len(runs))
+		}
+		if succeeded {
+			if failed > 0 {
+				log.Warn("Some targets failed, the run still succeeds because others did", "failed_targets", failed, "targets", len(runs))
+			}
+			return nil
+		}
+		log.Error("Fan-out failed", "failed_targets", failed, "targets", len(runs), "require_all", config.FanoutRequireAll, "success_threshold_percent", config.BatchSuccessThreshold)
+		return errors.Join(errs...)
+	}
+}
+
+// cancelOnStop ends the context of run when stopping is cancelled, so a shutdown
+// aborts an in-flight request instead of waiting for it to time out.
+func cancelOnStop(stopping context.Context, run func(ctx context.Context, log *slog.Logger) error) func(ctx context.Context, log *slog.Logger) error {
+	return func(ctx context.Context, log *slog.Logger) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		defer context.AfterFunc(stopping, cancel)()
+		err := run(ctx, log)
+		if err != nil && stopping.Err() != nil && errors.Is(err, context.Canceled) {
+			log.Warn("Request cancelled, the runner is shutting down")
+		}
+		return err
+	}
+}
+
+// jobHTTPClient returns the client an http job should use: the shared client unless
+// the job needs different client-level behaviour.
+func jobHTTPClient(shared *http.Client, config Config) (*http.Client, error) {
+	customTLS := config.CAFile != "" || config.ClientCert != "" || config.InsecureSkipVerify
+	customTransport := config.DialTimeout != 0 || config.DialKeepAlive != 0 || config.DNSRefresh != 0 || customTLS
+	if config.ExpectRedirect == "" && !customTransport && config.HTTPTimeout == 0 {
+		return shared, nil
+	}
+
+	client := *shared
+	if config.HTTPTimeout > 0 {
+		// The job's own timeout is applied to the request context instead, so the
+		// shared HTTP_CLIENT_TIMEOUT can't cut it short.
+		client.Timeout = 0
+	}
+	if config.ExpectRedirect != "" {
+		// Redirects are asserted rather than followed.
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	if customTransport {
+		transport := jobTransport(config)
+		if customTLS {
+			tlsConfig, err := jobTLSConfig(config)
+			if err != nil {
+				return nil, err
+			}
+			transport.TLSClientConfig = tlsConfig
+		}
+		client.Transport = transport
+	}
+	return &client, nil
+}
+
+// jobTLSConfig loads the job's CRON_CA_FILE and client certificate and applies
+// CRON_INSECURE_SKIP_VERIFY. It returns nil if the job has none of them.
+func jobTLSConfig(config Config) (*tls.Config, error) {
+	if config.CAFile == "" && config.ClientCert == "" && !config.InsecureSkipVerify {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CRON_CA_FILE: %w", err)
+		}
+		// The private CA is trusted in addition to the system's CAs.
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid CRON_CA_FILE %q: no PEM certificates found", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CRON_CLIENT_CERT/CRON_CLIENT_KEY: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// jobDialer returns the dialer of the job's connections, with its CRON_DIAL_TIMEOUT
+// and CRON_DIAL_KEEPALIVE applied to Go's defaults.
+func jobDialer(config Config) *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if config.DialTimeout != 0 {
+		dialer.Timeout = config.DialTimeout
+	}
+	if config.DialKeepAlive != 0 {
+		dialer.KeepAlive = config.DialKeepAlive
+	}
+	return dialer
+}
+
+// jobTransport builds a dedicated transport for a job, starting from Go's default
+// transport settings and applying the job's dialer tuning.
+func jobTransport(config Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = jobDialer(config).DialContext
+	if config.DNSRefresh < 0 {
+		// Every request dials, and therefore resolves the host, anew.
+		transport.DisableKeepAlives = true
+	}
+	return transport
+}
+
+// connRefresher implements an interval CRON_DNS_REFRESH: Go resolves the host only
+// when it dials, so pooled connections keep going to whatever IPs the host had back
+// then. Dropping them once the interval has passed forces a fresh lookup.
+type connRefresher struct {
+	every time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// newConnRefresher returns nil unless the job refreshes on an interval.
+func newConnRefresher(every time.Duration) *connRefresher {
+	if every <= 0 {
+		return nil
+	}
+	return &connRefresher{every: every, last: time.Now()}
+}
+
+// maybeRefresh closes the idle connections of the job's client if the refresh
+// interval has passed.
+func (r *connRefresher) maybeRefresh(log *slog.Logger, client *http.Client) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.last) < r.every {
+		return
+	}
+	client.CloseIdleConnections()
+	r.last = time.Now()
+	log.Debug("Closed idle connections to re-resolve the target host", "dns_refresh", r.every.String())
+}
+
+// checkRedirect verifies that resp is a redirect to expected. A relative Location is
+// resolved against the request URL before comparing.
+func checkRedirect(resp *http.Response, expected string) error {
+	if resp.StatusCode < 300 || resp.StatusCode > 399 {
+		return fmt.Errorf("expected a redirect, got status %s", resp.Status)
+	}
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return fmt.Errorf("redirect status %s without a Location header", resp.Status)
+	}
+	if location == expected {
+		return nil
+	}
+	if resolved, err := resp.Location(); err == nil && resolved.String() == expected {
+		return nil
+	}
+	return fmt.Errorf("redirected to %q, expected %q", location, expected)
+}
+
+// statusRange is an inclusive range of HTTP status codes from CRON_SUCCESS_STATUS.
+type statusRange struct {
+	Min, Max int
+}
+
+// parseStatusRanges parses a CRON_SUCCESS_STATUS value: comma-separated status codes
+// or ranges such as "200-299,404".
+func parseStatusRanges(v string) ([]statusRange, error) {
+	var ranges []statusRange
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		if !isRange {
+			last = first
+		}
+		lo, err1 := strconv.Atoi(strings.TrimSpace(first))
+		hi, err2 := strconv.Atoi(strings.TrimSpace(last))
+		if err1 != nil || err2 != nil || lo < 100 || hi > 599 || lo > hi {
+			return nil, fmt.Errorf("malformed status %q: expected a code or range between 100 and 599", part)
+		}
+		ranges = append(ranges, statusRange{lo, hi})
+	}
+	if len(ranges) == 0 {
+		return nil, errors.New("no status codes given")
+	}
+	return ranges, nil
+}
+
+// statusAccepted reports whether a response with status code counts as a success.
+// Without CRON_SUCCESS_STATUS ranges, every status below 400 does.
+func statusAccepted(ranges []statusRange, code int) bool {
+	if ranges == nil {
+		return code < 400
+	}
+	for _, r := range ranges {
+		if code >= r.Min && code <= r.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHeaders parses a CRON_HTTP_HEADERS value: comma-separated "Key: Value" pairs.
+func parseHeaders(v string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(v, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("malformed header %q: expected Key:Value", strings.TrimSpace(pair))
+		}
+		headers[http.CanonicalHeaderKey(key)] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+// setAcceptEncoding applies a job's CRON_ACCEPT_GZIP setting to req. With no explicit
+// setting the header is left alone and the Go transport negotiates (and transparently
+// decodes) gzip itself.
+func setAcceptEncoding(req *http.Request, acceptGzip *bool) {
+	if acceptGzip == nil {
+		return
+	}
+	if *acceptGzip {
+		req.Header.Set("Accept-Encoding", "gzip")
+	} else {
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+}
+
+// defaultResponseBodyLogLimit is the default HTTP_DEBUG_BODY_BYTES.
+const defaultResponseBodyLogLimit = 2048
+
+// responseBodyLogLimit is how many bytes of the body of a failed response are logged
+// (HTTP_LOG_RESPONSE_BODY). Zero logs none.
+var responseBodyLogLimit int
+
+// responseBodyExcerpt returns up to limit bytes of the (decoded) body of resp. The
+// rest of the body is not read.
+func responseBodyExcerpt(resp *http.Response, limit int) string {
+	body, _, err := decodedBody(resp)
+	if err != nil {
+		return ""
+	}
+	excerpt, _ := io.ReadAll(io.LimitReader(body, int64(limit)))
+	return string(excerpt)
+}
+
+// decodedBody returns a reader over the decoded response body and a description of
+// the encoding seen on the wire. When Accept-Encoding was set explicitly the transport
+// leaves gzip bodies compressed, so they are decoded here.
+func decodedBody(resp *http.Response) (io.Reader, string, error) {
+	if resp.Uncompressed {
+		return resp.Body, "gzip (decoded by transport)", nil
+	}
+
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "":
+		return resp.Body, "identity", nil
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(resp.Body)
+		if err == io.EOF {
+			// An empty body (e.g. 204 No Content) carries no gzip header.
+			return strings.NewReader(""), encoding, nil
+		}
+		if err != nil {
+			return nil, encoding, err
+		}
+		return zr, encoding, nil
+	default:
+		return resp.Body, encoding, nil
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// runHTTPJob makes a single request of an http job to its CRON_TARGET_URL and checks
+// the response: its status, an expected redirect and, for conditional and
+// change-detecting jobs, whether the resource changed.
+func runHTTPJob(ctx context.Context, log *slog.Logger, client *http.Client, config Config) error {
+	targetURL, err := renderTemplate(config.TargetURL, jobNow(config), config.Name)
+	if err != nil {
+		log.Error("Failed to render the target URL", "error", err)
+		return err
+	}
+	if err := httpRateLimit.wait(ctx, log); err != nil {
+		log.Warn("Gave up waiting for the HTTP rate limit", "error", err)
+		return err
+	}
+	log.Info("Executing job", "target", redactURL(targetURL), "method", config.HTTPMethod)
+	if config.HTTPTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.HTTPTimeout)
+		defer cancel()
+	}
+	if config.Preflight {
+		if err := preflight(ctx, config, targetURL); err != nil {
+			log.Error("Preflight connectivity check failed, skipping request", "error", err)
+			return err
+		}
+	}
+	var reqBody io.Reader
+	if config.HTTPBody != "" {
+		reqBody = strings.NewReader(config.HTTPBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, config.HTTPMethod, targetURL, reqBody)
+	if err != nil {
+		log.Error("Failed to create request", "error", err)
+		return err
+	}
+	req.Header.Set("User-Agent", config.UserAgent)
+	for key, value := range config.HTTPHeaders {
+		req.Header.Set(key, value)
+	}
+	if config.HTTPContentType != "" {
+		req.Header.Set("Content-Type", config.HTTPContentType)
+	}
+	// Set after the custom headers so the configured credentials and body signature always win.
+	setAuth(req, config)
+	signBody(req, config)
+	req.Header.Set(correlationHeader, correlationID(ctx))
+	setAcceptEncoding(req, config.AcceptGzip)
+	if config.Conditional {
+		conditionalCache.apply(config.Name, req)
+	}
+	// Signed last so the signature covers every header set above.
+	if config.AWSSigV4 {
+		if err := signSigV4(ctx, req, config.HTTPBody, config.AWSRegion, config.AWSService); err != nil {
+			log.Error("Failed to sign request with AWS SigV4", "error", err)
+			return err
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		err = redactURLError(err)
+		log.Error("Failed to execute request", "method", config.HTTPMethod, "target", redactURL(targetURL), "error", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if config.ExpectRedirect != "" {
+		if err := checkRedirect(resp, config.ExpectRedirect); err != nil {
+			log.Error("Redirect check failed", "status", resp.Status, "location", resp.Header.Get("Location"), "expected_location", config.ExpectRedirect, "error", err)
+			return err
+		}
+		log.Info("Job completed successfully", "status", resp.Status, "location", resp.Header.Get("Location"))
+		return nil
+	}
+
+	// A conditional job's 304 is its "unchanged" success, handled below.
+	notModified := config.Conditional && resp.StatusCode == http.StatusNotModified
+	if !statusAccepted(config.SuccessStatus, resp.StatusCode) && !notModified {
+		fields := []interface{}{"status", resp.Status, "method", config.HTTPMethod, "target", redactURL(targetURL)}
+		if responseBodyLogLimit > 0 {
+			fields = append(fields, "response_body", responseBodyExcerpt(resp, responseBodyLogLimit))
+		}
+		log.Error("Request failed", fields...)
+		return &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+
+	if config.Conditional {
+		if resp.StatusCode == http.StatusNotModified {
+			recordChange(ctx, false)
+			log.Info("Job completed successfully, resource unchanged", "status", resp.Status, "changed", false)
+			return nil
+		}
+	}
+
+	// Count the body as it is read off the connection, before any gzip decoding here.
+	counted := &countingReader{ReadCloser: resp.Body}
+	resp.Body = counted
+	body, encoding, err := decodedBody(resp)
+	hash := sha256.New()
+	if err == nil {
+		// Drain the body so the connection can be reused and a corrupt
+		// compressed stream is reported rather than silently ignored.
+		dst := io.Discard
+		if config.DetectChanges {
+			dst = hash
+		}
+		_, err = io.Copy(dst, body)
+	}
+	if err != nil {
+		log.Error("Failed to read response body", "status", resp.Status, "content_encoding", encoding, "response_bytes", counted.n, "error", err)
+		return fmt.Errorf("reading response body: %w", err)
+	}
+	metrics.observeResponseBytes(config.Name, counted.n)
+	if config.Conditional {
+		// Only a response that was read in full may serve as the base of the next
+		// conditional request; a cut-off one has to be fetched again.
+		conditionalCache.update(config.Name, resp)
+	}
+	fields := []interface{}{"status", resp.Status, "content_encoding", encoding, "response_bytes", counted.n}
+	if config.DetectChanges || config.Conditional {
+		// A full response to a conditional request means the resource changed.
+		changed := true
+		if config.DetectChanges {
+			changed = conditionalCache.compareBody(config.Name, hex.EncodeToString(hash.Sum(nil)))
+		}
+		recordChange(ctx, changed)
+		fields = append(fields, "changed", changed)
+	}
+	log.Info("Job completed successfully", fields...)
+	return nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCancelOnStopAbortsInFlightRequest(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	received := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		// Block until the client gives up on the request.
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	stopping, stop := context.WithCancel(context.Background())
+	defer stop()
+	client := &http.Client{Timeout: time.Minute}
+	run := cancelOnStop(stopping, func(ctx context.Context, log *slog.Logger) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- run(context.Background(), log) }()
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request never reached the server")
+	}
+	started := time.Now()
+	stop()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("error = %v, want it to wrap context.Canceled", err)
+		}
+		if elapsed := time.Since(started); elapsed > 5*time.Second {
+			t.Errorf("request took %s to be aborted after the stop", elapsed)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("request was not aborted on stop")
+	}
+	if !strings.Contains(buf.String(), "Request cancelled, the runner is shutting down") {
+		t.Errorf("no cancellation log record in:\n%s", buf.String())
+	}
+}
+
+func TestRunHTTPJobStatusHandling(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		if err != nil {
+			code = http.StatusBadRequest
+		}
+		w.WriteHeader(code)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		status     int
+		success    []statusRange
+		wantStatus int // 0 for success
+	}{
+		{name: "ok", status: 200},
+		{name: "redirect status counts as success", status: 304},
+		{name: "server error", status: 503, wantStatus: 503},
+		{name: "client error", status: 404, wantStatus: 404},
+		{name: "listed in CRON_SUCCESS_STATUS", status: 404, success: []statusRange{{404, 404}}},
+		{name: "not listed in CRON_SUCCESS_STATUS", status: 200, success: []statusRange{{204, 204}}, wantStatus: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{
+				Name:          "test",
+				TargetURL:     fmt.Sprintf("%s/%d", srv.URL, tt.status),
+				HTTPMethod:    http.MethodGet,
+				SuccessStatus: tt.success,
+			}
+			err := runHTTPJob(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), srv.Client(), config)
+			var status *statusError
+			switch {
+			case tt.wantStatus == 0 && err != nil:
+				t.Errorf("error = %v, want success", err)
+			case tt.wantStatus != 0 && !errors.As(err, &status):
+				t.Errorf("error = %v, want a status error", err)
+			case tt.wantStatus != 0 && status.code != tt.wantStatus:
+				t.Errorf("status = %d, want %d", status.code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRunHTTPJobSendsConfiguredRequest(t *testing.T) {
+	var got *http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got, body = r, string(b)
+	}))
+	defer srv.Close()
+
+	config := Config{
+		Name:            "test",
+		TargetURL:       srv.URL + "/hook",
+		HTTPMethod:      http.MethodPost,
+		HTTPBody:        `{"ping":true}`,
+		HTTPContentType: "application/json",
+		HTTPHeaders:     map[string]string{"X-Tenant-Id": "acme"},
+		UserAgent:       "test-agent",
+		SecretToken:     "s3cret",
+		AuthType:        authBearer,
+	}
+	if err := runHTTPJob(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), srv.Client(), config); err != nil {
+		t.Fatalf("runHTTPJob: %v", err)
+	}
+	for header, want := range map[string]string{
+		"Authorization": "Bearer s3cret",
+		"Content-Type":  "application/json",
+		"User-Agent":    "test-agent",
+		"X-Tenant-Id":   "acme",
+	} {
+		if v := got.Header.Get(header); v != want {
+			t.Errorf("%s = %q, want %q", header, v, want)
+		}
+	}
+	if got.Method != http.MethodPost || got.URL.Path != "/hook" || body != config.HTTPBody {
+		t.Errorf("request = %s %s %q, want POST /hook %q", got.Method, got.URL.Path, body, config.HTTPBody)
+	}
+}
+
+func TestRunHTTPJobRetriesErrorResponses(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	config := Config{
+		Name:             "test",
+		TargetURL:        srv.URL,
+		HTTPMethod:       http.MethodGet,
+		RespRetries:      2,
+		RespRetryBackoff: time.Millisecond,
+	}
+	run := withHTTPRetries(config, nil, func(ctx context.Context, log *slog.Logger) error {
+		return runHTTPJob(ctx, log, srv.Client(), config)
+	})
+	if err := run(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		t.Fatalf("error = %v, want success on the last retry", err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("requests = %d, want 3", n)
+	}
+}
+
+func TestRetryBudgetIsSharedAcrossRuns(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	config := Config{
+		Name:             "test",
+		TargetURL:        srv.URL,
+		HTTPMethod:       http.MethodGet,
+		RespRetries:      2,
+		RespRetryBackoff: time.Millisecond,
+	}
+	budget := newRetryBudget(3)
+	run := withHTTPRetries(config, budget, func(ctx context.Context, log *slog.Logger) error {
+		return runHTTPJob(ctx, log, srv.Client(), config)
+	})
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// The first run uses two of the three retries, the second the last one and the
+	// third none at all.
+	for i, want := range []int32{3, 5, 6} {
+		if err := run(context.Background(), log); err == nil {
+			t.Fatalf("run %d succeeded, want failure", i+1)
+		}
+		if n := requests.Load(); n != want {
+			t.Errorf("after run %d: requests = %d, want %d", i+1, n, want)
+		}
+	}
+}
+
+func TestRetryDelayIsCapped(t *testing.T) {
+	tests := []struct {
+		initial time.Duration
+		n       int
+		want    time.Duration
+	}{
+		{time.Second, 1, time.Second},
+		{time.Second, 3, 4 * time.Second},
+		{time.Second, 12, 2048 * time.Second},
+		{time.Second, 13, maxRetryBackoff},
+		{time.Second, 40, maxRetryBackoff},
+		{time.Second, 64, maxRetryBackoff},
+		{time.Second, 65, maxRetryBackoff},
+		{time.Millisecond, 60, maxRetryBackoff},
+		{2 * time.Hour, 40, 2 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.initial, tt.n); got != tt.want {
+			t.Errorf("retryDelay(%s, %d) = %s, want %s", tt.initial, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestSecretFilesReloadRotatedSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("old\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config := Config{SecretToken: "old", SecretFiles: map[string]string{"CRON_SECRET": path}}
+	secrets := newSecretFiles(http.DefaultClient, http.DefaultClient, config)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	if got, _ := secrets.apply(log, config, http.DefaultClient); got.SecretToken != "old" {
+		t.Errorf("before rotation: secret = %q, want %q", got.SecretToken, "old")
+	}
+	if err := os.WriteFile(path, []byte("rotated\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := secrets.apply(log, config, http.DefaultClient); got.SecretToken != "rotated" {
+		t.Errorf("after rotation: secret = %q, want %q", got.SecretToken, "rotated")
+	}
+}
+
+func TestFanOutBatchThresholdAndTimeout(t *testing.T) {
+	ok := func(ctx context.Context, log *slog.Logger) error { return nil }
+	slow := func(ctx context.Context, log *slog.Logger) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	runs := []func(ctx context.Context, log *slog.Logger) error{ok, ok, ok, slow}
+	urls := []string{"http://a", "http://b", "http://c", "http://d"}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	for _, tc := range []struct {
+		threshold float64
+		wantErr   bool
+	}{
+		{threshold: 75, wantErr: false},
+		{threshold: 90, wantErr: true},
+	} {
+		config := Config{BatchURLTimeout: 10 * time.Millisecond, BatchSuccessThreshold: tc.threshold}
+		err := fanOut(config, urls, runs)(context.Background(), log)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("threshold %v%%: error = %v, want error: %v", tc.threshold, err, tc.wantErr)
+		}
+		if err != nil && !strings.Contains(err.Error(), "exceeded BATCH_URL_TIMEOUT") {
+			t.Errorf("threshold %v%%: error = %v, want the slow target to time out", tc.threshold, err)
+		}
+	}
+}
+
+func TestConditionalCacheKeepsValidatorsOfTruncatedResponse(t *testing.T) {
+	cache := conditionalCache
+	conditionalCache = newValidatorCacheFromEnv(discardLog)
+	defer func() { conditionalCache = cache }()
+
+	var ifNoneMatch []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifNoneMatch = append(ifNoneMatch, r.Header.Get("If-None-Match"))
+		w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, len(ifNoneMatch)))
+		if len(ifNoneMatch) == 1 {
+			// Promise more than is sent: the client sees an unexpected EOF.
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte("partial"))
+			return
+		}
+		w.Write([]byte("full"))
+	}))
+	defer srv.Close()
+
+	config := Config{Name: "test", TargetURL: srv.URL, HTTPMethod: http.MethodGet, Conditional: true}
+	if err := runHTTPJob(context.Background(), discardLog, srv.Client(), config); err == nil {
+		t.Fatal("truncated response succeeded, want a read error")
+	}
+	for i := 0; i < 2; i++ {
+		if err := runHTTPJob(context.Background(), discardLog, srv.Client(), config); err != nil {
+			t.Fatalf("request %d: %v", i+2, err)
+		}
+	}
+	if want := []string{"", "", `"v2"`}; !reflect.DeepEqual(ifNoneMatch, want) {
+		t.Errorf("If-None-Match headers = %q, want %q", ifNoneMatch, want)
+	}
+}
+
+func TestPreflightUsesRunContext(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	config := Config{DialTimeout: time.Second}
+	if err := preflight(context.Background(), config, srv.URL); err != nil {
+		t.Fatalf("preflight to a listening server: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := preflight(ctx, config, srv.URL); !errors.Is(err, context.Canceled) {
+		t.Errorf("preflight after the run was cancelled: err = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// idleMonitor implements IDLE_SHUTDOWN: once every job has run at least once and no
+// job is due within the idle window, it asks the runner to shut down gracefully.
+type idleMonitor struct {
+	window time.Duration
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	pending map[string]bool // jobs that have not had a run yet
+}
+
+// newIdleMonitor returns nil when idle shutdown is disabled (window <= 0).
+func newIdleMonitor(window time.Duration, configs []Config, logger *slog.Logger) *idleMonitor {
+	if window <= 0 {
+		return nil
+	}
+	pending := make(map[string]bool, len(configs))
+	for _, config := range configs {
+		pending[config.Name] = true
+	}
+	return &idleMonitor{window: window, logger: logger, pending: pending}
+}
+
+// markRan records that the named job has had a run, whether it completed, was
+// skipped or panicked. Safe on a nil monitor.
+func (m *idleMonitor) markRan(name string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	delete(m.pending, name)
+	m.mu.Unlock()
+}
+
+// track updates the jobs waited for after a reload: added jobs have not run yet and
+// removed jobs no longer need to. Safe on a nil monitor.
+func (m *idleMonitor) track(added, removed []string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, name := range added {
+		m.pending[name] = true
+	}
+	for _, name := range removed {
+		delete(m.pending, name)
+	}
+}
+
+// watch periodically inspects the scheduler and sends SIGTERM to quit once idle.
+func (m *idleMonitor) watch(c *cron.Cron, quit chan<- os.Signal) {
+	if m == nil {
+		return
+	}
+
+	interval := 10 * time.Second
+	if m.window < interval {
+		interval = m.window
+	}
+	m.logger.Info("Idle shutdown enabled", "idle_window", m.window.String(), "check_interval", interval.String())
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			m.mu.Lock()
+			waiting := len(m.pending)
+			m.mu.Unlock()
+			if waiting > 0 {
+				continue
+			}
+
+			now := time.Now()
+			var earliest time.Time
+			for _, entry := range c.Entries() {
+				if entry.Next.IsZero() {
+					continue
+				}
+				if earliest.IsZero() || entry.Next.Before(earliest) {
+					earliest = entry.Next
+				}
+			}
+			if !earliest.IsZero() && earliest.Sub(now) <= m.window {
+				continue
+			}
+
+			fields := []interface{}{"idle_window", m.window.String(), "reason", "all jobs have run at least once and none is scheduled within the idle window"}
+			if !earliest.IsZero() {
+				fields = append(fields, "next_run", earliest, "next_run_in", earliest.Sub(now).Round(time.Second).String())
+			}
+			m.logger.Info("Idle shutdown triggered", fields...)
+			quit <- syscall.SIGTERM
+			return
+		}
+	}()
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+func TestIdleShutdownAfterSkippedRun(t *testing.T) {
+	idle := newIdleMonitor(10*time.Millisecond, []Config{{Name: "backup"}}, discardLog)
+	quit := make(chan os.Signal, 1)
+	idle.watch(cron.New(), quit)
+
+	select {
+	case <-quit:
+		t.Fatal("idle shutdown before the job had a run")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// A run that returns early, like a skipped one, still counts.
+	run := func() error {
+		defer idle.markRan("backup")
+		return &skippedError{reason: errStillRunning}
+	}
+	run()
+	select {
+	case <-quit:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no idle shutdown after the job's run was skipped")
+	}
+}
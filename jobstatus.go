@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// jobStatus is one job in the GET /jobs listing.
+type jobStatus struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Schedule string `json:"schedule"`
+	// NextRun is null for jobs that are not on the schedule: @startup jobs, jobs
+	// waiting for their CRON_START_AFTER delay and disabled jobs.
+	NextRun    *time.Time `json:"next_run"`
+	LastRun    *time.Time `json:"last_run"`
+	LastResult string     `json:"last_result,omitempty"` // "success" or "failure"
+	LastError  string     `json:"last_error,omitempty"`
+	RunCount   int        `json:"run_count"`
+	// FailureCount is how many of the RunCount runs failed, and SlowCount how many
+	// succeeded but exceeded CRON_SLOW_THRESHOLD; LastSlow marks a slow last run.
+	FailureCount int  `json:"failure_count"`
+	SlowCount    int  `json:"slow_count"`
+	LastSlow     bool `json:"last_slow,omitempty"`
+	// SkipCount is how many runs didn't start, e.g. under CRON_OVERLAP_POLICY=skip.
+	SkipCount int `json:"skip_count"`
+	// Disabled marks jobs taken off the schedule by CRON_MAX_CONSECUTIVE_FAILURES.
+	Disabled bool `json:"disabled,omitempty"`
+	// Flapping marks jobs whose FlapScore, the share of outcome changes within
+	// CRON_FLAP_WINDOW, reached CRON_FLAP_THRESHOLD.
+	Flapping  bool    `json:"flapping,omitempty"`
+	FlapScore float64 `json:"flap_score,omitempty"`
+}
+
+// jobDirectory lists the scheduled jobs for GET /jobs. main replaces the list whenever
+// the jobs change; the handler reads it concurrently.
+type jobDirectory struct {
+	mu    sync.Mutex
+	c     *cron.Cron
+	stats *runStats
+	jobs  []*scheduledJob
+}
+
+// set replaces the listed jobs, in the order they are configured.
+func (d *jobDirectory) set(c *cron.Cron, stats *runStats, jobs []*scheduledJob) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.c, d.stats, d.jobs = c, stats, jobs
+}
+
+// list returns the status of every listed job.
+func (d *jobDirectory) list() []jobStatus {
+	d.mu.Lock()
+	c, stats, jobs := d.c, d.stats, d.jobs
+	d.mu.Unlock()
+
+	list := make([]jobStatus, 0, len(jobs))
+	for _, j := range jobs {
+		status := jobStatus{
+			Name:     j.config.Name,
+			Type:     j.config.JobType,
+			Schedule: j.config.Schedule,
+			Disabled: j.breaker.disabled(),
+		}
+		status.Flapping, status.FlapScore = j.flaps.state()
+		if id := cron.EntryID(j.probe.id.Load()); id != 0 && !status.Disabled {
+			if next := c.Entry(id).Next; !next.IsZero() {
+				status.NextRun = &next
+			}
+		}
+		run := stats.get(j.config.Name)
+		status.RunCount, status.FailureCount, status.SlowCount = run.runs, run.failures, run.slow
+		status.SkipCount = run.skipped
+		if !run.lastRun.IsZero() {
+			status.LastRun = &run.lastRun
+			status.LastResult = "failure"
+			if run.lastSuccess {
+				status.LastResult = "success"
+			}
+			status.LastError, status.LastSlow = run.lastError, run.lastSlow
+		}
+		list = append(list, status)
+	}
+	return list
+}
+
+// ServeHTTP implements GET /jobs.
+func (d *jobDirectory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.list())
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// newLoggerFromEnv builds the runner's logger: JSON at info level unless LOG_FORMAT
+// and LOG_LEVEL say otherwise. Invalid values fall back to the defaults and are logged.
+func newLoggerFromEnv(w io.Writer) *slog.Logger {
+	levelValue, badLevel := os.Getenv("LOG_LEVEL"), false
+	level := slog.LevelInfo
+	if levelValue != "" {
+		if err := level.UnmarshalText([]byte(levelValue)); err != nil {
+			level, badLevel = slog.LevelInfo, true
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	formatValue := strings.ToLower(os.Getenv("LOG_FORMAT"))
+	var handler slog.Handler = slog.NewJSONHandler(w, opts)
+	if formatValue == "text" {
+		handler = slog.NewTextHandler(w, opts)
+	}
+
+	logger := slog.New(handler)
+	if badLevel {
+		logger.Error("Invalid LOG_LEVEL, using default", "value", levelValue, "default", "info")
+	}
+	if formatValue != "" && formatValue != "json" && formatValue != "text" {
+		logger.Error("Invalid LOG_FORMAT, using default", "value", formatValue, "default", "json")
+	}
+	return logger
+}
+
+// parseLogSample parses a CRON_LOG_SAMPLE value of the form "1/N" (or just "N")
+// and returns N, the number of successful runs per logged run.
+func parseLogSample(v string) (int, error) {
+	v = strings.TrimSpace(v)
+	if num, den, ok := strings.Cut(v, "/"); ok {
+		if strings.TrimSpace(num) != "1" {
+			return 0, fmt.Errorf("sample rate %q must be of the form 1/N", v)
+		}
+		v = den
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("sample rate %q must be of the form 1/N with N >= 1", v)
+	}
+	return n, nil
+}
+
+// logSampler selects 1 in every n runs of a job for full info-level logging.
+type logSampler struct {
+	n     uint64
+	count atomic.Uint64
+}
+
+func newLogSampler(n int) *logSampler {
+	if n <= 1 {
+		return nil
+	}
+	return &logSampler{n: uint64(n)}
+}
+
+// sample reports whether the next run should be logged at info level. The first run
+// is always sampled. A nil sampler samples every run.
+func (s *logSampler) sample() bool {
+	if s == nil {
+		return true
+	}
+	return (s.count.Add(1)-1)%s.n == 0
+}
+
+// demoteHandler rewrites records logged at level from to level to, leaving other
+// levels intact. Unsampled runs use it to demote info to debug, so failures are still
+// reported while routine success chatter is hidden at the default level; possibly
+// transient failures use it to demote errors to warnings.
+type demoteHandler struct {
+	slog.Handler
+	from, to slog.Level
+}
+
+func (h demoteHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if level == h.from {
+		level = h.to
+	}
+	return h.Handler.Enabled(ctx, level)
+}
+
+func (h demoteHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level == h.from {
+		r.Level = h.to
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h demoteHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return demoteHandler{h.Handler.WithAttrs(attrs), h.from, h.to}
+}
+
+func (h demoteHandler) WithGroup(name string) slog.Handler {
+	return demoteHandler{h.Handler.WithGroup(name), h.from, h.to}
+}
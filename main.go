@@ -1,100 +1,32 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"errors"
-	"fmt"
+	"encoding/json"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
-	"strings"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/robfig/cron/v3"
 )
 
-// Config holds the configuration for a SINGLE cron job.
-type Config struct {
-	Name     string // A friendly name for logging purposes.
-	Schedule string
-	JobType  string // "http" or "shell"
+// processStart is when the runner started; it anchors STARTUP_GRACE.
+var processStart = time.Now()
 
-	// Fields for "http" type
-	TargetURL   string
-	SecretToken string
+// startupGrace is the STARTUP_GRACE period during which job failures are logged but
+// do not raise alerts or count towards failure thresholds.
+var startupGrace time.Duration
 
-	// Fields for "shell" type
-	ShellCommand         string
-	ShellTargetContainer string
-}
-
-// loadConfigs loads configurations for ALL jobs from environment variables.
-func loadConfigs(logger *slog.Logger) []Config {
-	var configs []Config
-
-	// Search for jobs in an infinite loop, looking for CRON_SCHEDULE_i
-	for i := 1; ; i++ {
-		scheduleKey := fmt.Sprintf("CRON_SCHEDULE_%d", i)
-		schedule := os.Getenv(scheduleKey)
-
-		// If a schedule for the current index is not found, we assume there are no more jobs.
-		if schedule == "" {
-			break
-		}
-
-		jobType := os.Getenv(fmt.Sprintf("JOB_TYPE_%d", i))
-		if jobType == "" {
-			jobType = "http" // Default job type
-		}
-
-		jobName := os.Getenv(fmt.Sprintf("JOB_NAME_%d", i))
-		if jobName == "" {
-			jobName = fmt.Sprintf("job_#%d", i) // Default job name
-		}
-
-		config := Config{
-			Name:     jobName,
-			Schedule: schedule,
-			JobType:  jobType,
-		}
-
-		var validationError error
-
-		switch jobType {
-		case "http":
-			config.TargetURL = os.Getenv(fmt.Sprintf("CRON_TARGET_URL_%d", i))
-			config.SecretToken = os.Getenv(fmt.Sprintf("CRON_SECRET_%d", i))
-			if config.TargetURL == "" {
-				validationError = errors.New("CRON_TARGET_URL is required")
-			}
-			if config.SecretToken == "" {
-				validationError = errors.New("CRON_SECRET is required")
-			}
-		case "shell":
-			config.ShellCommand = os.Getenv(fmt.Sprintf("SHELL_COMMAND_%d", i))
-			if config.ShellCommand == "" {
-				validationError = errors.New("SHELL_COMMAND is required")
-			}
-			config.ShellTargetContainer = os.Getenv(fmt.Sprintf("SHELL_TARGET_CONTAINER_%d", i))
-		default:
-			validationError = errors.New("unknown JOB_TYPE: " + jobType)
-		}
-
-		if validationError != nil {
-			logger.Error("Skipping invalid job configuration", "job_name", config.Name, "reason", validationError)
-			continue // Skip this job and move to the next one
-		}
-
-		configs = append(configs, config)
-		logger.Info("Successfully loaded job configuration", "job_name", config.Name, "schedule", config.Schedule, "type", config.JobType)
-	}
-
-	return configs
+// inStartupGrace reports whether the runner is still within its startup grace period.
+func inStartupGrace() bool {
+	return startupGrace > 0 && time.Since(processStart) < startupGrace
 }
 
 // SlogCronLogger is an adapter to allow the cron library to use our main slog.Logger.
@@ -109,143 +41,728 @@ func (s SlogCronLogger) Error(err error, msg string, keysAndValues ...interface{
 	s.Logger.Error(msg, append([]interface{}{"error", err}, keysAndValues...)...)
 }
 
+// configuredJobs is the number of loaded job configurations, reported by /healthz.
+var configuredJobs atomic.Int64
+
+// healthCheckMux serves GET /healthz, POST /resume for runners started with
+// START_PAUSED, the GET /jobs listing of directory and the status page at GET / that
+// renders it. DASHBOARD_TOKEN protects all but /healthz.
+func healthCheckMux(logger *slog.Logger, gate *pauseGate, directory *jobDirectory) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Status        string  `json:"status"`
+			JobCount      int64   `json:"job_count"`
+			UptimeSeconds float64 `json:"uptime_seconds"`
+			Paused        bool    `json:"paused"`
+		}{"ok", configuredJobs.Load(), time.Since(processStart).Seconds(), gate.paused()})
+	})
+	dashboardToken := os.Getenv("DASHBOARD_TOKEN")
+	mux.Handle("/jobs", requireToken(dashboardToken, directory))
+	mux.Handle("/", requireToken(dashboardToken, dashboardHandler()))
+	mux.Handle("/resume", requireToken(dashboardToken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		// The caller's X-Correlation-Id is carried by the runs the resume starts.
+		runID := r.Header.Get(correlationHeader)
+		if runID != "" && !validCorrelationID(runID) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid " + correlationHeader))
+			return
+		}
+		if !gate.resume(runID) {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("Not paused"))
+			return
+		}
+		logger.Info("Resume requested, starting scheduler", "remote_addr", r.RemoteAddr, "correlation_id", runID)
+		w.Write([]byte("Resumed"))
+	})))
+	return mux
+}
+
 // startHealthCheckServer starts a lightweight HTTP server on a separate goroutine
-// to respond to Docker's health checks.
-func startHealthCheckServer(logger *slog.Logger) {
-	listener, err := net.Listen("tcp", ":8081")
+// to respond to Docker's health checks, with the routes of healthCheckMux. An empty
+// HEALTH_PORT disables the server and nil is returned.
+func startHealthCheckServer(logger *slog.Logger, gate *pauseGate, directory *jobDirectory) *http.Server {
+	port, ok := os.LookupEnv("HEALTH_PORT")
+	if !ok {
+		port = "8081"
+	}
+	if port == "" {
+		logger.Info("Healthcheck server disabled, HEALTH_PORT is empty")
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", ":"+port)
 	if err != nil {
 		logger.Error("Healthcheck server failed to start", "error", err)
 		os.Exit(1) // If we can't start the healthcheck, the app is faulty
 	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
-
-	logger.Info("Healthcheck server starting on :8081")
+	logger.Info("Healthcheck server starting", "addr", listener.Addr().String())
 
 	// Run the server in a background goroutine so it doesn't block the main app.
+	server := &http.Server{Handler: healthCheckMux(logger, gate, directory)}
 	go func() {
-		if err := http.Serve(listener, mux); err != nil && err != http.ErrServerClosed {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
 			logger.Error("Healthcheck server crashed", "error", err)
 		}
 	}()
+	return server
 }
 
 func main() {
-	// 1. Set up structured JSON logger.
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	// 1. Set up the structured logger, JSON at info level by default.
+	logger := newLoggerFromEnv(os.Stdout)
+
+	// SELF_TEST only checks the runtime dependencies of the configured jobs.
+	if envBool("SELF_TEST") {
+		os.Exit(selfTest(logger))
+	}
+	// DRY_RUN lists the configured jobs and their next run times.
+	if envBool("DRY_RUN") {
+		os.Exit(dryRun(logger))
+	}
 
-	// 2. Start the internal health check server.
-	startHealthCheckServer(logger)
+	// RUN_NOW runs a single job once instead of starting the scheduler. It is often
+	// started next to a running runner, so it opens no ports of its own.
+	runNowJob := os.Getenv("RUN_NOW")
+
+	// With START_PAUSED the scheduler only starts on POST /resume.
+	gate := newPauseGate(envBool("START_PAUSED"))
+
+	// 2. Start the internal health check server. Its job listing is filled in once
+	// the jobs are scheduled.
+	directory := &jobDirectory{}
+	var health *http.Server
+	if runNowJob == "" {
+		health = startHealthCheckServer(logger, gate, directory)
+	}
+	if health == nil && envBool("START_PAUSED") && runNowJob == "" {
+		logger.Warn("START_PAUSED is set but the healthcheck server is disabled, the scheduler can't be resumed")
+	}
 
 	logger.Info("Starting multi-job CRON runner...")
 
+	startupGrace = envDuration(logger, "STARTUP_GRACE", 0)
+
+	// Check once whether docker exec jobs can work in this container.
+	dockerAvailable = detectDocker(logger)
+	dockerExecSlots = newSemaphore(envInt(logger, "MAX_CONCURRENT_DOCKER_EXEC", 3))
+	if envBool("HTTP_LOG_RESPONSE_BODY") {
+		responseBodyLogLimit = envInt(logger, "HTTP_DEBUG_BODY_BYTES", defaultResponseBodyLogLimit)
+	}
+	httpRateLimit = newRateLimiter(envFloat(logger, "HTTP_RATE_LIMIT", 0), envInt(logger, "HTTP_RATE_BURST", 1))
+	if httpRateLimit != nil {
+		logger.Info("HTTP requests are rate limited", "requests_per_second", httpRateLimit.rate, "burst", int(httpRateLimit.burst))
+	}
+
 	// 3. Load all job configurations from environment variables.
-	configs := loadConfigs(logger)
+	configs, invalid := loadConfigs(logger)
+	strict := envBool("STRICT_CONFIG")
+	// Schedules whose next run is further away than this are reported as suspicious.
+	scheduleHorizon := envDuration(logger, "SCHEDULE_HORIZON", 366*24*time.Hour)
+	if strict {
+		invalid += countNeverFiring(logger, configs, scheduleHorizon)
+	}
+	if strict && invalid > 0 {
+		logger.Error("Invalid job configurations, refusing to start under STRICT_CONFIG", "invalid_jobs", invalid)
+		os.Exit(1)
+	}
+	if !resolveDuplicateNames(logger, configs, strict) {
+		os.Exit(1)
+	}
+	configuredJobs.Store(int64(len(configs)))
 	if len(configs) == 0 {
-		logger.Warn("No valid jobs configured. Exiting.")
-		os.Exit(0)
+		if os.Getenv("CONFIG_FILE") == "" || configFileMissingPolicy(logger) != missingFileEmpty {
+			logger.Warn("No valid jobs configured. Exiting.")
+			os.Exit(0)
+		}
+		// CONFIG_FILE_MISSING_POLICY=empty: the jobs come with a reload once the file exists.
+		logger.Warn("No jobs configured, waiting for a SIGHUP to load CONFIG_FILE")
+	}
+
+	// Optionally hold off until the containers docker exec jobs target are healthy.
+	waitForContainers(logger, configs, envDuration(logger, "WAIT_FOR_CONTAINER_HEALTH", 0))
+
+	// Machine-readable lifecycle events for external controllers.
+	events := newEventStreamFromEnv(logger)
+
+	// Optionally ship completed-run records to an external HTTP sink in batches.
+	sink := newResultsSinkFromEnv(logger)
+	sink.Start()
+
+	// Optionally expose Prometheus metrics on METRICS_PORT.
+	if runNowJob == "" {
+		metrics = newJobMetricsFromEnv(logger)
 	}
 
 	// 4. Create a reusable HTTP client and a new cron scheduler.
-	httpClient := &http.Client{Timeout: 60 * time.Second}
+	// HTTP_CLIENT_TIMEOUT bounds every http request; 0 leaves it to the run's context.
+	httpClient := &http.Client{Timeout: envDuration(logger, "HTTP_CLIENT_TIMEOUT", 60*time.Second)}
 	cronLogger := SlogCronLogger{Logger: logger}
-	c := cron.New(cron.WithChain(
+	options := []cron.Option{cron.WithChain(
 		// Recover prevents the entire runner from crashing if a job panics.
 		cron.Recover(cronLogger),
-	))
+	)}
+	if envBool("CRON_WITH_SECONDS") {
+		options = append(options, cron.WithSeconds())
+	}
+	c := cron.New(options...)
+
+	// Failed runs are optionally appended to a dead-letter file for later replay.
+	deadLetters := newDeadLetterLogFromEnv(logger)
+	audit := newAuditLogFromEnv(logger)
+	notifications := newNotifierFromEnv(logger)
+	goroutines := newGoroutineGuardFromEnv(logger)
+	startMaintenance(logger, deadLetters)
+
+	conditionalCache = newValidatorCacheFromEnv(logger)
+
+	// The time of each job's latest run, kept across restarts to detect missed runs.
+	state := newRunStateFromEnv(logger)
+	runMissed := envBool("RUN_MISSED")
+	if runMissed && state == nil {
+		logger.Warn("RUN_MISSED has no effect without STATE_FILE")
+	}
 
-	// 5. Iterate over all loaded configurations and create a job for each.
-	for _, config := range configs {
-		// IMPORTANT: Create a local copy of the config variable for the closure.
-		jobConf := config
+	// Optional idle shutdown for ephemeral, batch-style deployments.
+	idle := newIdleMonitor(envDuration(logger, "IDLE_SHUTDOWN", 0), configs, logger)
+
+	// Completed runs are counted for the summary logged at shutdown.
+	stats := newRunStats()
+
+	// {{.LastRun}} placeholders see the runs of this process, else those of STATE_FILE.
+	templateEpoch = envTime(logger, "TEMPLATE_EPOCH", templateEpoch)
+	lastRunOf = func(job string) (time.Time, bool, bool) {
+		if run := stats.get(job); run.runs > 0 {
+			return run.lastRun, run.lastSuccess, true
+		}
+		return state.last(job)
+	}
+
+	// Jobs sharing a concurrency group share one semaphore.
+	groups, err := newGroupSemaphores(configs, logger)
+	if err != nil {
+		logger.Error("Invalid concurrency group limit", "error", err)
+		os.Exit(1)
+	}
+
+	// MAX_CONCURRENT_JOBS caps the runs in progress across all jobs, whatever their
+	// group; SERIAL_MODE is a cap of one. Runs waiting for a slot start in
+	// CRON_PRIORITY order.
+	jobSlots, slotName := newJobPool(envInt(logger, "MAX_CONCURRENT_JOBS", 0)), "job"
+	if envBool("SERIAL_MODE") {
+		jobSlots, slotName = newJobPool(1), "serial mode"
+		logger.Info("Serial mode enabled, jobs run one at a time")
+	} else if jobSlots.limit > 0 {
+		logger.Info("Global job concurrency limit enabled", "max_concurrent_jobs", jobSlots.limit)
+	}
+	jobSlots.setPriorities(configs)
+
+	// Runs starting later than this after their scheduled time are reported.
+	driftWarn := envDuration(logger, "SCHEDULE_DRIFT_WARN", 10*time.Second)
+
+	// Jobs disabled by CRON_MAX_CONSECUTIVE_FAILURES are reminded of this often.
+	disabledReminder := envDuration(logger, "DISABLED_JOB_REMINDER", 0)
+
+	// SHUTDOWN_TIMEOUT bounds the wait for running jobs on shutdown; 0 waits for as
+	// long as they take.
+	shutdownTimeout := envDuration(logger, "SHUTDOWN_TIMEOUT", 30*time.Second)
+
+	// stopping is cancelled on shutdown so that runs waiting to retry give up and
+	// in-flight http requests are aborted.
+	stopping, stop := shutdownContext()
+	defer stop()
 
-		var job func()
+	overlaps := newOverlapGuards()
+
+	// acquireSlots waits for a slot of the job's concurrency group, if any, and then
+	// for its turn in the job pool. Waiting runs give up on shutdown instead of
+	// starting one after another.
+	acquireSlots := func(log *slog.Logger, config Config, groupSlots semaphore) (func(), error) {
+		releaseGroup, err := groupSlots.acquire(stopping, log.With("group", config.ConcurrencyGroup), "concurrency group")
+		if err != nil {
+			return nil, err
+		}
+		releaseTurn, err := jobSlots.acquire(stopping, log, slotName, config.Priority)
+		if err != nil {
+			releaseGroup()
+			return nil, err
+		}
+		return func() {
+			releaseTurn()
+			releaseGroup()
+		}, nil
+	}
+
+	// 5. newJob creates the job of a loaded configuration, ready to be scheduled.
+	newJob := func(jobConf Config) *scheduledJob {
+		// run executes a single attempt of the job and reports its outcome.
+		var run func(ctx context.Context, log *slog.Logger) error
+		// budget is shared by the job's runs and, for fan-out jobs, its targets.
+		budget := newRetryBudget(jobConf.MaxRetriesPerDay)
 		switch jobConf.JobType {
 		case "http":
-			job = func() {
-				log := logger.With("job_name", jobConf.Name, "type", "http")
-				log.Info("Executing job", "target", jobConf.TargetURL)
-				req, err := http.NewRequest("GET", jobConf.TargetURL, nil)
-				if err != nil {
-					log.Error("Failed to create request", "error", err)
-					return
+			client, err := jobHTTPClient(httpClient, jobConf)
+			if err != nil {
+				logger.Error("Failed to add CRON job", "job_name", jobConf.Name, "error", err)
+				return nil
+			}
+			refresher := newConnRefresher(jobConf.DNSRefresh)
+			secrets := newSecretFiles(httpClient, client, jobConf)
+			// attempt requests the job's target; a fan-out job has one per CRON_TARGET_URL.
+			attempt := func(jobConf Config) func(ctx context.Context, log *slog.Logger) error {
+				return withHTTPRetries(jobConf, budget, func(ctx context.Context, log *slog.Logger) error {
+					jobConf, client := secrets.apply(log, jobConf, client)
+					refresher.maybeRefresh(log, client)
+					return runHTTPJob(ctx, log, client, jobConf)
+				})
+			}
+			if len(jobConf.TargetURLs) > 1 {
+				targets := make([]func(ctx context.Context, log *slog.Logger) error, len(jobConf.TargetURLs))
+				for i, targetURL := range jobConf.TargetURLs {
+					target := jobConf
+					target.TargetURL = targetURL
+					targets[i] = attempt(target)
 				}
-				req.Header.Set("Authorization", "Bearer "+jobConf.SecretToken)
+				run = fanOut(jobConf, jobConf.TargetURLs, targets)
+			} else {
+				run = attempt(jobConf)
+			}
+			// The requests of a run are aborted on shutdown rather than holding it up.
+			run = cancelOnStop(stopping, run)
 
-				resp, err := httpClient.Do(req)
-				if err != nil {
-					log.Error("Failed to execute request", "error", err)
-					return
-				}
-				defer resp.Body.Close()
+		case "shell":
+			run = func(ctx context.Context, log *slog.Logger) error {
+				return runShellJob(ctx, log, jobConf)
+			}
 
-				if resp.StatusCode >= 400 {
-					log.Error("Request failed", "status", resp.Status)
-					return
-				}
-				log.Info("Job completed successfully", "status", resp.Status)
+		case "cert_expiry":
+			run = func(ctx context.Context, log *slog.Logger) error {
+				return checkCertExpiry(ctx, log, jobConf)
 			}
 
-		case "shell":
-			job = func() {
-				log := logger.With("job_name", jobConf.Name, "type", "shell")
-				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		case "docker_run":
+			run = func(ctx context.Context, log *slog.Logger) error {
+				ctx, cancel := context.WithTimeout(ctx, jobConf.ShellTimeout)
 				defer cancel()
 
-				var cmd *exec.Cmd
-				logFields := []interface{}{"command", jobConf.ShellCommand}
-
-				if jobConf.ShellTargetContainer == "" {
-					log.Info("Executing local shell command")
-					cmd = exec.CommandContext(ctx, "sh", "-c", jobConf.ShellCommand)
-				} else {
-					logFields = append(logFields, "target_container", jobConf.ShellTargetContainer)
-					log.Info("Executing remote shell command via docker exec", logFields...)
-					cmd = exec.CommandContext(ctx, "docker", "exec", jobConf.ShellTargetContainer, "sh", "-c", jobConf.ShellCommand)
+				if err := runDockerRun(ctx, log, jobConf); err != nil {
+					return err
 				}
+				log.Info("Job completed successfully")
+				return nil
+			}
+		}
 
-				var outb, errb bytes.Buffer
-				cmd.Stdout = &outb
-				cmd.Stderr = &errb
-
-				err := cmd.Run()
-				if outb.Len() > 0 {
-					log.Info("Command stdout", "output", strings.TrimSpace(outb.String()))
+		run = withRetries(jobConf, stopping, budget, run)
+
+		groupSlots := groups[jobConf.ConcurrencyGroup]
+		sampler := newLogSampler(jobConf.LogSampleEvery)
+		flaps := newFlapDetector(jobConf.FlapThreshold, jobConf.FlapWindow)
+		transients := newTransientFilter(jobConf.TransientFailures)
+		breaker := newFailureBreaker(jobConf.MaxConsecutiveFailures)
+		probe := &driftProbe{c: c}
+		overlap := overlaps.get(jobConf.Name)
+		// execute runs the job once, started by trigger, and returns its outcome.
+		execute := func(trigger, runID string) error {
+			// Every outcome counts as the job's turn for IDLE_SHUTDOWN, skips and panics
+			// included; otherwise a job whose runs are skipped keeps the runner alive.
+			defer idle.markRan(jobConf.Name)
+			if runID == "" {
+				runID = newCorrelationID()
+			}
+			ctx := withCorrelationID(context.Background(), runID)
+			log := logger.With("job_name", jobConf.Name, "type", jobConf.JobType, "correlation_id", runID)
+			if jobConf.RoutingKey != "" {
+				log = log.With("routing_key", jobConf.RoutingKey)
+			}
+			scheduled := trigger == auditTriggerScheduled
+			// Measured before any wait for the previous run or a concurrency group, which is
+			// not the scheduler's fault.
+			if drift, ok := probe.drift(time.Now()); ok && scheduled {
+				metrics.observeDrift(jobConf.Name, drift)
+				if driftWarn > 0 && drift > driftWarn {
+					log.Warn("Job started late, the host may be overloaded", "schedule_drift", drift.Round(time.Millisecond).String(), "drift_threshold", driftWarn.String())
+				}
+			}
+			if !sampler.sample() {
+				// Unsampled runs log at debug level; warnings and errors still get through.
+				log = slog.New(demoteHandler{log.Handler(), slog.LevelInfo, slog.LevelDebug})
+			}
+			lenient := transients.lenient()
+			runLog := log
+			if lenient {
+				// A failure right after a success may be a one-off blip: report it as a warning.
+				runLog = slog.New(demoteHandler{log.Handler(), slog.LevelError, slog.LevelWarn})
+			}
+			// skip reports a run that doesn't start for reason.
+			skip := func(reason error) error {
+				events.emit(jobSkippedEvent(jobConf, runID, reason))
+				metrics.observeSkip(jobConf.Name)
+				stats.observeSkip(jobConf.Name)
+				return &skippedError{reason: reason}
+			}
+			// A run queued before the breaker tripped doesn't start anymore.
+			if breaker.disabled() {
+				log.Warn("Skipping run, the job is disabled after too many consecutive failures")
+				return skip(errJobDisabled)
+			}
+			if goroutines.shedding() {
+				log.Warn("Skipping run, the runner is over MAX_GOROUTINES", "goroutines", runtime.NumGoroutine())
+				return skip(errGoroutineLimit)
+			}
+			// With CRON_OVERLAP_POLICY=delay, queued runs still execute during a graceful shutdown.
+			leave, overlapErr := overlap.enter(context.Background(), log, jobConf.OverlapPolicy)
+			if overlapErr != nil {
+				log.Warn("Previous run still in progress, skipping this run")
+				return skip(overlapErr)
+			}
+			defer leave()
+			// Jitter is waited out before any slot is taken, and given up on shutdown.
+			if scheduled {
+				if err := sleepJitter(stopping, log, jobConf.Jitter); err != nil {
+					return skip(err)
 				}
-				if errb.Len() > 0 {
-					log.Error("Command stderr", "output", strings.TrimSpace(errb.String()))
+			}
+			release, waitErr := acquireSlots(log, jobConf, groupSlots)
+			if waitErr != nil {
+				return skip(waitErr)
+			}
+			defer release()
+
+			startedAt := time.Now()
+			events.emit(jobEvent(eventJobStarted, jobConf, runID))
+			sendStartPing(log, jobConf.StartPingURL, runID)
+			ctx, change := withChangeOutcome(ctx)
+			err := run(ctx, runLog)
+			events.emit(jobFinishedEvent(jobConf, runID, startedAt, err))
+			rec := newRunRecord(jobConf, startedAt, err)
+			rec.CorrelationID = runID
+			if err == nil && change.Known {
+				rec.Changed = &change.Changed
+			}
+			if err == nil && jobConf.SlowThreshold > 0 && rec.FinishedAt.Sub(startedAt) > jobConf.SlowThreshold {
+				rec.Slow = true
+				log.Warn("Job succeeded but exceeded its slow threshold", "slow", true, "duration", rec.FinishedAt.Sub(startedAt).String(), "slow_threshold", jobConf.SlowThreshold.String())
+			}
+			if err != nil && inStartupGrace() {
+				rec.StartupGrace = true
+				log.Warn("Job failed during startup grace period, alerting suppressed", "grace_remaining", (startupGrace - time.Since(processStart)).Round(time.Second).String())
+			}
+			switch transients.observe(err == nil) {
+			case verdictTransient:
+				rec.Transient = true
+				log.Warn("Job failed after a successful run, treating it as transient unless the next run fails too", "transient", true)
+			case verdictSustained:
+				log.Error("Job failed again, the previous failure was not transient", "consecutive_failures", 2)
+			case verdictIsolated:
+				log.Info("Job recovered, the previous failure was an isolated blip")
+			}
+			if flapping, changed, score := flaps.observe(err == nil); changed {
+				if flapping {
+					log.Warn("Job is flapping between success and failure", "flap_score", score, "flap_threshold", jobConf.FlapThreshold, "window", jobConf.FlapWindow)
+					notifications.notifyFlapping(jobConf, score)
+				} else {
+					log.Info("Job is no longer flapping", "flap_score", score)
 				}
-				if err != nil {
-					log.Error("Shell command failed to execute", "error", err)
-					return
+			}
+			if tripped, failures := breaker.observeRun(rec); tripped {
+				if id := cron.EntryID(probe.id.Load()); id != 0 {
+					c.Remove(id)
 				}
-				log.Info("Job completed successfully")
+				log.Error("Job disabled after too many consecutive failures, it was removed from the schedule", "consecutive_failures", failures, "max_consecutive_failures", jobConf.MaxConsecutiveFailures)
+				breaker.remind(logger.With("job_name", jobConf.Name), disabledReminder)
 			}
+			sink.Record(rec)
+			audit.Record(jobConf, trigger, rec)
+			metrics.observeRun(rec)
+			stats.observe(rec)
+			state.record(rec)
+			if err != nil {
+				deadLetters.Record(jobConf, runID, startedAt, err)
+				// Failures that are expected to clear up on their own don't alert.
+				if !rec.StartupGrace && !rec.Transient {
+					notifications.notifyFailure(jobConf, err)
+				}
+			}
+			return err
+		}
+		job := func() { execute(auditTriggerScheduled, "") }
+
+		// A @startup job runs once when the scheduler starts and has no schedule.
+		var schedule cron.Schedule
+		if jobConf.Schedule != scheduleAtStartup {
+			var err error
+			schedule, err = parseSchedule(jobConf)
+			if err != nil {
+				logger.Error("Failed to add CRON job", "job_name", jobConf.Name, "error", err)
+				return nil
+			}
+			warnIfScheduleNeverFires(logger, jobConf, schedule, scheduleHorizon)
+		}
+		return &scheduledJob{
+			config:   jobConf,
+			schedule: schedule,
+			job:      cron.FuncJob(job),
+			probe:    probe,
+			breaker:  breaker,
+			flaps:    flaps,
+			execute:  execute,
 		}
+	}
 
-		// Add the newly created job to the cron scheduler.
-		_, err := c.AddFunc(jobConf.Schedule, job)
-		if err != nil {
-			logger.Error("Failed to add CRON job", "job_name", jobConf.Name, "error", err)
+	// Add every job to the cron scheduler. Jobs are tracked by name so a SIGHUP
+	// reload can reconcile them; those with a CRON_START_AFTER delay start their
+	// timers with the scheduler, and @startup jobs run once when it starts.
+	jobs := make(map[string]*scheduledJob, len(configs))
+	var startDelayed, startupJobs []*scheduledJob
+	for _, config := range configs {
+		j := newJob(config)
+		if j == nil {
+			continue
+		}
+		jobs[config.Name] = j
+		switch {
+		case config.Schedule == scheduleAtStartup:
+			startupJobs = append(startupJobs, j)
+		case config.StartAfter > 0:
+			startDelayed = append(startDelayed, j)
+		default:
+			j.start(c, logger, true)
 		}
 	}
 
-	// 6. Start the cron scheduler.
-	c.Start()
-	logger.Info("CRON scheduler started with configured jobs.", "job_count", len(c.Entries()))
+	directory.set(c, stats, jobsInOrder(jobs, configs))
 
-	// 7. Set up graceful shutdown.
+	if runNowJob != "" {
+		code := runNow(logger, jobs, runNowJob, os.Getenv("RUN_NOW_CORRELATION_ID"), newExitCodesFromEnv(logger))
+		sink.Close()
+		notifications.Close()
+		os.Exit(code)
+	}
+
+	// RELOAD_CANARY runs a shell job whose commands a reload changed once, with its
+	// new configuration, before the change is applied. The run is held to the job's
+	// limits like a scheduled one, but all its waits give up on shutdown: the reload
+	// holds up the signal loop.
+	var canary func(Config) error
+	if envBool("RELOAD_CANARY") {
+		canary = func(config Config) error {
+			log := logger.With("job_name", config.Name, "type", config.JobType, "trigger", "canary")
+			log.Info("Shell command changed, running it once before applying the change")
+			leave, err := overlaps.get(config.Name).enter(stopping, log, config.OverlapPolicy)
+			if err != nil {
+				return err
+			}
+			defer leave()
+			// groups is only written by reloads, which run on this goroutine.
+			release, err := acquireSlots(log, config, groups[config.ConcurrencyGroup])
+			if err != nil {
+				return err
+			}
+			defer release()
+			return runShellJob(stopping, log, config)
+		}
+	}
+
+	// reload implements SIGHUP: the configuration is loaded again and the scheduler
+	// reconciled with it.
+	reload := func() {
+		logger.Info("Received SIGHUP, reloading job configuration")
+		reloaded, invalid, err := readConfigs(logger)
+		switch {
+		case err != nil:
+			logger.Error("Reload failed, keeping the current jobs", "error", err)
+			return
+		case invalid > 0:
+			logger.Error("Reloaded configuration has invalid jobs, keeping the current jobs", "invalid_jobs", invalid)
+			return
+		case !resolveDuplicateNames(logger, reloaded, envBool("STRICT_CONFIG")):
+			logger.Error("Reloaded configuration has duplicate job names, keeping the current jobs")
+			return
+		case len(reloaded) == 0:
+			logger.Error("Reloaded configuration has no jobs, keeping the current jobs")
+			return
+		}
+		if url := os.Getenv("RELOAD_VALIDATION_URL"); url != "" && !validateReload(logger, url, reloaded) {
+			return
+		}
+		// Concurrency groups that are new need their semaphores before their jobs are created.
+		var newGroups []Config
+		for _, config := range reloaded {
+			if _, ok := groups[config.ConcurrencyGroup]; config.ConcurrencyGroup != "" && !ok {
+				newGroups = append(newGroups, config)
+			}
+		}
+		newSlots, err := newGroupSemaphores(newGroups, logger)
+		if err != nil {
+			logger.Error("Reloaded configuration has an invalid concurrency group limit, keeping the current jobs", "error", err)
+			return
+		}
+		for group, slots := range newSlots {
+			groups[group] = slots
+		}
+
+		jobSlots.setPriorities(reloaded)
+		added, removed := reconcileJobs(logger, c, jobs, reloaded, newJob, canary)
+		idle.track(added, removed)
+		configuredJobs.Store(int64(len(jobs)))
+		directory.set(c, stats, jobsInOrder(jobs, reloaded))
+	}
+
+	// 6. Set up graceful shutdown and configuration reloads.
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit // Block until a signal is received.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	// STARTUP_FAIL_FATAL shuts the runner down, with a non-zero exit code, when a
+	// @startup job fails, so e.g. a failed migration blocks the deploy.
+	startupFailFatal := envBool("STARTUP_FAIL_FATAL")
+	var startupRuns sync.WaitGroup
+	var startupFailed atomic.Bool
+
+	// 7. Start the cron scheduler after STARTUP_DELAY, once resumed if the runner
+	// started paused.
+	startupDelay := envDuration(logger, "STARTUP_DELAY", 0)
+	if gate != nil {
+		logger.Info("Runner started paused, waiting for POST /resume", "job_count", len(c.Entries())+len(startDelayed)+len(startupJobs))
+	}
+	if waitStartupDelay(logger, startupDelay, quit) && gate.wait(quit) {
+		c.Start()
+		for _, j := range startDelayed {
+			j.start(c, logger, true)
+		}
+		for _, j := range startupJobs {
+			startupRuns.Add(1)
+			go func(j *scheduledJob) {
+				defer startupRuns.Done()
+				logger.Info("Running startup job", "job_name", j.config.Name)
+				if err := j.execute(auditTriggerStartup, gate.correlationID()); err != nil && startupFailFatal {
+					logger.Error("Startup job failed, shutting down because STARTUP_FAIL_FATAL is set", "job_name", j.config.Name, "error", err)
+					startupFailed.Store(true)
+					select {
+					case quit <- syscall.SIGTERM:
+					default: // A shutdown is pending already.
+					}
+				}
+			}(j)
+		}
+		// Scheduled runs that fell into a downtime are caught up once, not once per missed run.
+		for _, config := range configs {
+			j := jobs[config.Name]
+			if j == nil || j.schedule == nil {
+				continue
+			}
+			last, first, missed := state.missed(config.Name, j.schedule, time.Now())
+			if missed == 0 {
+				continue
+			}
+			logger.Warn("Job missed scheduled runs while the runner was down", "job_name", config.Name, "last_run", last.LastRun, "first_missed_run", first, "missed_runs", missed, "catching_up", runMissed)
+			if !runMissed {
+				continue
+			}
+			startupRuns.Add(1)
+			go func(j *scheduledJob) {
+				defer startupRuns.Done()
+				if err := j.execute(auditTriggerCatchUp, gate.correlationID()); err != nil {
+					logger.Error("Catch-up run failed", "job_name", j.config.Name, "error", err)
+					return
+				}
+				logger.Info("Caught up missed run", "job_name", j.config.Name)
+			}(j)
+		}
+		for _, config := range configs {
+			if j := jobs[config.Name]; j != nil {
+				j.logNextRun(c, logger)
+			}
+		}
+		logger.Info("CRON scheduler started with configured jobs.", "job_count", len(c.Entries()), "delayed_job_count", len(startDelayed), "startup_job_count", len(startupJobs))
+		jobCount := len(c.Entries()) + len(startDelayed) + len(startupJobs)
+		events.emit(event{Event: eventSchedulerStarted, JobCount: &jobCount})
+		idle.watch(c, quit)
+	}
+	// Block until a shutdown signal is received, reloading on every SIGHUP.
+	// RELOAD_DEBOUNCE collects the SIGHUPs arriving within that long of the first one
+	// into a single reload, so a burst of signals doesn't reload over and over.
+	reloadDebounce := envDuration(logger, "RELOAD_DEBOUNCE", time.Second)
+	var debounce <-chan time.Time
+	signals := 0
+running:
+	for {
+		select {
+		case <-quit:
+			break running
+		case <-hup:
+			signals++
+			if reloadDebounce == 0 {
+				signals = 0
+				reload()
+			} else if signals == 1 {
+				debounce = time.After(reloadDebounce)
+			}
+		case <-debounce:
+			if signals > 1 {
+				logger.Info("Coalesced SIGHUP signals into one reload", "signals", signals, "reload_debounce", reloadDebounce.String())
+			}
+			debounce, signals = nil, 0
+			reload()
+		}
+	}
 
 	logger.Info("Shutting down CRON runner...")
+	stop()
+	for _, j := range jobs {
+		j.stopTimer()
+	}
 	// Stop the scheduler and wait for any running jobs to finish.
 	shutdownCtx := c.Stop()
-	<-shutdownCtx.Done()
+	finished := make(chan struct{})
+	go func() {
+		<-shutdownCtx.Done()
+		startupRuns.Wait()
+		close(finished)
+	}()
+	var timeout <-chan time.Time
+	if shutdownTimeout > 0 {
+		timeout = time.After(shutdownTimeout)
+	}
+	timedOut := false
+	select {
+	case <-finished:
+	case <-timeout:
+		timedOut = true
+		logger.Warn("Jobs still running after the shutdown timeout, exiting without waiting for them", "shutdown_timeout", shutdownTimeout.String())
+	}
+	names := make([]string, 0, len(jobs))
+	for name := range jobs {
+		names = append(names, name)
+	}
+	stats.logSummary(logger, names)
+	// Deliver any run records still buffered for the results sink.
+	sink.Close()
+	notifications.Close()
+	metrics.Close()
+	events.emit(event{Event: eventShutdown})
+	if health != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := health.Shutdown(ctx); err != nil {
+			logger.Error("Healthcheck server did not shut down cleanly", "error", err)
+		}
+		cancel()
+	}
+	if timedOut {
+		logger.Warn("CRON runner shut down, running jobs were abandoned.")
+		os.Exit(1)
+	}
 	logger.Info("CRON runner shut down gracefully.")
+	if startupFailed.Load() {
+		os.Exit(1)
+	}
 }
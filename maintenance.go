@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseRetention parses a RETENTION value: a Go duration ("720h") or a number of days ("30d").
+func parseRetention(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number of days %q", v)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", v)
+	}
+	return d, nil
+}
+
+// startMaintenance runs the runner's own housekeeping in the background: it prunes the
+// dead-letter file to RETENTION (default 30 days) and RETENTION_MAX_ENTRIES, once at
+// startup and then every RETENTION_CHECK_INTERVAL. The other files are left alone on
+// purpose: the audit log is a hash chain, and the state and conditional cache files
+// keep one overwritten entry per job rather than growing with every run.
+func startMaintenance(logger *slog.Logger, deadLetters *deadLetterLog) {
+	if deadLetters == nil {
+		return
+	}
+	log := logger.With("component", "maintenance")
+
+	retention := 30 * 24 * time.Hour
+	if v := os.Getenv("RETENTION"); v != "" {
+		d, err := parseRetention(v)
+		if err != nil {
+			log.Error("Invalid RETENTION, using default", "value", v, "default", retention.String(), "error", err)
+		} else {
+			retention = d
+		}
+	}
+	maxEntries := envInt(logger, "RETENTION_MAX_ENTRIES", 0)
+	interval := envDuration(logger, "RETENTION_CHECK_INTERVAL", time.Hour)
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	log.Info("Runner file maintenance enabled", "retention", retention.String(), "max_entries", maxEntries, "interval", interval.String())
+
+	prune := func() {
+		removed, kept, err := deadLetters.prune(retention, maxEntries)
+		if err != nil {
+			log.Error("Failed to prune dead-letter file", "path", deadLetters.path, "error", err)
+			return
+		}
+		if removed > 0 {
+			log.Info("Pruned dead-letter file", "path", deadLetters.path, "removed", removed, "kept", kept)
+		}
+	}
+
+	go func() {
+		prune()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			prune()
+		}
+	}()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics records job runs when METRICS_PORT is set, and is nil otherwise. It is set
+// once at startup.
+var metrics *jobMetrics
+
+// jobMetrics exposes job runs in the Prometheus format on METRICS_PORT.
+// A nil *jobMetrics is valid and records nothing.
+type jobMetrics struct {
+	runs          *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	responseBytes *prometheus.HistogramVec
+	drift         *prometheus.GaugeVec
+	noops         *prometheus.CounterVec
+	slow          *prometheus.CounterVec
+	skips         *prometheus.CounterVec
+	server        *http.Server
+	logger        *slog.Logger
+}
+
+func newJobMetricsFromEnv(logger *slog.Logger) *jobMetrics {
+	port := os.Getenv("METRICS_PORT")
+	if port == "" {
+		return nil
+	}
+
+	m := &jobMetrics{
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cronjob_runs_total",
+			Help: "Completed job runs by result (success or error).",
+		}, []string{"job", "result"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cronjob_duration_seconds",
+			Help:    "Duration of job runs, including retries.",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+		}, []string{"job"}),
+		responseBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cronjob_response_bytes",
+			Help:    "Size of the response bodies read by http jobs.",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 10),
+		}, []string{"job"}),
+		drift: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cronjob_schedule_drift_seconds",
+			Help: "How late the last run of the job started after its scheduled time.",
+		}, []string{"job"}),
+		noops: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cronjob_noop_total",
+			Help: "Successful runs of change-detection jobs that found nothing new.",
+		}, []string{"job"}),
+		slow: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cron_job_slow_total",
+			Help: "Successful runs that took longer than the job's CRON_SLOW_THRESHOLD.",
+		}, []string{"job"}),
+		skips: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cronjob_skipped_total",
+			Help: "Runs that didn't start, e.g. because the previous run was still in progress.",
+		}, []string{"job"}),
+		logger: logger,
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(m.runs, m.duration, m.responseBytes, m.drift, m.noops, m.slow, m.skips)
+
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		logger.Error("Metrics server failed to start", "error", err)
+		os.Exit(1)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	m.server = &http.Server{Handler: mux}
+	go func() {
+		if err := m.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Error("Metrics server crashed", "error", err)
+		}
+	}()
+	logger.Info("Metrics server starting", "addr", listener.Addr().String())
+	return m
+}
+
+// observeRun counts a completed run and records its duration.
+func (m *jobMetrics) observeRun(rec runRecord) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if !rec.Success {
+		result = "error"
+	}
+	m.runs.WithLabelValues(rec.Job, result).Inc()
+	m.duration.WithLabelValues(rec.Job).Observe(rec.FinishedAt.Sub(rec.StartedAt).Seconds())
+	if rec.Changed != nil && !*rec.Changed {
+		m.noops.WithLabelValues(rec.Job).Inc()
+	}
+	if rec.Slow {
+		m.slow.WithLabelValues(rec.Job).Inc()
+	}
+}
+
+// observeSkip counts a run of job that didn't start.
+func (m *jobMetrics) observeSkip(job string) {
+	if m == nil {
+		return
+	}
+	m.skips.WithLabelValues(job).Inc()
+}
+
+// observeResponseBytes records the size of a response body read by an http job.
+func (m *jobMetrics) observeResponseBytes(job string, n int64) {
+	if m == nil {
+		return
+	}
+	m.responseBytes.WithLabelValues(job).Observe(float64(n))
+}
+
+// observeDrift records how late the current run of job started.
+func (m *jobMetrics) observeDrift(job string, drift time.Duration) {
+	if m == nil {
+		return
+	}
+	m.drift.WithLabelValues(job).Set(drift.Seconds())
+}
+
+// Close shuts the metrics server down.
+func (m *jobMetrics) Close() {
+	if m == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.server.Shutdown(ctx); err != nil {
+		m.logger.Error("Metrics server did not shut down cleanly", "error", err)
+	}
+}
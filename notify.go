@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notification sink formats of NOTIFY_WEBHOOK_URLS.
+const (
+	notifyGeneric   = "generic"
+	notifySlack     = "slack"
+	notifyDiscord   = "discord"
+	notifyPagerDuty = "pagerduty"
+)
+
+// discordFailureColor is the red sidebar of a Discord failure embed.
+const discordFailureColor = 0xcf222e
+
+// failureNotification is the JSON body of a generic sink. Text and Content carry a
+// readable summary for Slack and Discord webhooks respectively; the other fields are
+// for receivers that parse the message.
+type failureNotification struct {
+	// Title heads the Discord embed.
+	Title      string    `json:"-"`
+	Text       string    `json:"text"`
+	Content    string    `json:"content"`
+	Job        string    `json:"job"`
+	Type       string    `json:"type"`
+	Error      string    `json:"error"`
+	Time       time.Time `json:"time"`
+	RoutingKey string    `json:"routing_key,omitempty"`
+}
+
+// notifySink is one webhook that failure notifications are sent to.
+type notifySink struct {
+	format string
+	url    string
+	// token is sent as a bearer token, except for PagerDuty where it is the
+	// integration's routing key.
+	token string
+}
+
+// parseNotifySinks parses NOTIFY_WEBHOOK_URLS: one "format url [token]" entry per
+// line (or separated by ";;").
+func parseNotifySinks(v string) ([]notifySink, error) {
+	var sinks []notifySink
+	for _, entry := range splitList(v) {
+		fields := strings.Fields(entry)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("entry %q must be of the form \"format url [token]\"", fields[0])
+		}
+		sink := notifySink{format: strings.ToLower(fields[0]), url: fields[1]}
+		if len(fields) == 3 {
+			sink.token = fields[2]
+		}
+		switch sink.format {
+		case notifyGeneric, notifySlack, notifyDiscord:
+		case notifyPagerDuty:
+			if sink.token == "" {
+				return nil, fmt.Errorf("pagerduty sink %s needs the integration's routing key as its token", sinkHost(sink.url))
+			}
+		default:
+			return nil, fmt.Errorf("unknown format %q: must be one of generic, slack, discord, pagerduty", sink.format)
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// payload renders the notification in the sink's format.
+func (s notifySink) payload(n failureNotification) interface{} {
+	switch s.format {
+	case notifySlack:
+		return map[string]string{"text": n.Text}
+	case notifyDiscord:
+		return map[string]interface{}{
+			"content": n.Content,
+			"embeds": []map[string]interface{}{{
+				"title":       n.Title,
+				"description": n.Error,
+				"color":       discordFailureColor,
+				"timestamp":   n.Time.Format(time.RFC3339),
+				"fields": []map[string]interface{}{
+					{"name": "Job", "value": n.Job, "inline": true},
+					{"name": "Type", "value": n.Type, "inline": true},
+				},
+			}},
+		}
+	case notifyPagerDuty:
+		return map[string]interface{}{
+			"routing_key":  s.token,
+			"event_action": "trigger",
+			"dedup_key":    "easypanel-cron/" + n.Job,
+			"payload": map[string]interface{}{
+				"summary":        n.Text,
+				"source":         n.Job,
+				"severity":       "error",
+				"timestamp":      n.Time.Format(time.RFC3339),
+				"custom_details": n,
+			},
+		}
+	}
+	return n
+}
+
+// sinkHost is the host of a sink URL, which identifies the sink in logs without
+// revealing webhook secrets in the path.
+func sinkHost(raw string) string {
+	if u, err := url.Parse(raw); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return "invalid-url"
+}
+
+// notifier POSTs a message to every configured webhook whenever a job fails.
+// A nil *notifier is valid and sends nothing.
+type notifier struct {
+	sinks   []notifySink
+	logger  *slog.Logger
+	pending sync.WaitGroup
+}
+
+// newNotifierFromEnv reads the sinks of NOTIFY_WEBHOOK_URLS and the single sink of
+// NOTIFY_WEBHOOK_URL, in the NOTIFY_FORMAT format (generic by default). An invalid
+// NOTIFY_WEBHOOK_URLS or NOTIFY_FORMAT is fatal: silently losing alerts is worse
+// than not starting.
+func newNotifierFromEnv(logger *slog.Logger) *notifier {
+	var sinks []notifySink
+	if raw := os.Getenv("NOTIFY_WEBHOOK_URL"); raw != "" {
+		format := strings.ToLower(os.Getenv("NOTIFY_FORMAT"))
+		switch format {
+		case "":
+			format = notifyGeneric
+		case notifyGeneric, notifySlack, notifyDiscord:
+		default:
+			logger.Error("Invalid NOTIFY_FORMAT: must be one of generic, slack, discord", "notify_format", format)
+			os.Exit(1)
+		}
+		sinks = append(sinks, notifySink{format: format, url: raw})
+	}
+	parsed, err := parseNotifySinks(os.Getenv("NOTIFY_WEBHOOK_URLS"))
+	if err != nil {
+		logger.Error("Invalid NOTIFY_WEBHOOK_URLS", "error", err)
+		os.Exit(1)
+	}
+	sinks = append(sinks, parsed...)
+	if len(sinks) == 0 {
+		return nil
+	}
+	logger.Info("Failure notifications enabled", "sinks", len(sinks))
+	return &notifier{sinks: sinks, logger: logger}
+}
+
+// notifyFailure reports a failed run of config to every sink in the background, each
+// independently of the others. Delivery problems are only logged: a notification
+// never affects the run itself.
+func (n *notifier) notifyFailure(config Config, err error) {
+	if n == nil {
+		return
+	}
+	n.send(config, fmt.Sprintf("Cron job %q failed", config.Name), fmt.Sprintf("Cron job %q (%s) failed: %v", config.Name, config.JobType, err), err.Error())
+}
+
+// notifyFlapping reports that config started flapping between success and failure,
+// like notifyFailure. It is sent once per transition, not on every flapping run.
+func (n *notifier) notifyFlapping(config Config, score float64) {
+	if n == nil {
+		return
+	}
+	detail := fmt.Sprintf("flap score %.2f reached CRON_FLAP_THRESHOLD %.2f", score, config.FlapThreshold)
+	n.send(config, fmt.Sprintf("Cron job %q is flapping", config.Name), fmt.Sprintf("Cron job %q (%s) is flapping between success and failure: %s", config.Name, config.JobType, detail), detail)
+}
+
+// send delivers a notification about config to every sink in the background.
+func (n *notifier) send(config Config, title, summary, detail string) {
+	notification := failureNotification{
+		Title:      title,
+		Text:       summary,
+		Content:    summary,
+		Job:        config.Name,
+		Type:       config.JobType,
+		Error:      detail,
+		Time:       time.Now(),
+		RoutingKey: config.RoutingKey,
+	}
+	for _, sink := range n.sinks {
+		n.pending.Add(1)
+		go func(sink notifySink) {
+			defer n.pending.Done()
+			n.deliver(sink, config, notification)
+		}(sink)
+	}
+}
+
+// Close waits for the notifications still being delivered, each of which is bounded
+// by pingTimeout.
+func (n *notifier) Close() {
+	if n == nil {
+		return
+	}
+	n.pending.Wait()
+}
+
+func (n *notifier) deliver(sink notifySink, config Config, notification failureNotification) {
+	log := n.logger.With("job_name", config.Name, "sink_format", sink.format, "sink_host", sinkHost(sink.url))
+	body, err := json.Marshal(sink.payload(notification))
+	if err != nil {
+		log.Error("Failed to encode failure notification", "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sink.url, bytes.NewReader(body))
+	if err != nil {
+		log.Warn("Failed to create failure notification", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if sink.token != "" && sink.format != notifyPagerDuty {
+		req.Header.Set("Authorization", "Bearer "+sink.token)
+	}
+	resp, err := pingClient.Do(req)
+	if err != nil {
+		log.Warn("Failure notification could not be sent", "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		log.Warn("Failure notification was rejected", "status", resp.Status)
+		return
+	}
+	log.Info("Failure notification sent", "status", resp.Status)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNotifySinkPayload(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	n := failureNotification{
+		Title:   `Cron job "backup" failed`,
+		Text:    `Cron job "backup" (shell) failed: exit status 1`,
+		Content: `Cron job "backup" (shell) failed: exit status 1`,
+		Job:     "backup",
+		Type:    "shell",
+		Error:   "exit status 1",
+		Time:    at,
+	}
+
+	for _, tc := range []struct {
+		format string
+		token  string
+		want   string
+	}{
+		{
+			format: notifyGeneric,
+			want:   `{"text":"Cron job \"backup\" (shell) failed: exit status 1","content":"Cron job \"backup\" (shell) failed: exit status 1","job":"backup","type":"shell","error":"exit status 1","time":"2026-01-02T03:04:05Z"}`,
+		},
+		{
+			format: notifySlack,
+			want:   `{"text":"Cron job \"backup\" (shell) failed: exit status 1"}`,
+		},
+		{
+			format: notifyDiscord,
+			want: `{"content":"Cron job \"backup\" (shell) failed: exit status 1","embeds":[{"title":"Cron job \"backup\" failed","description":"exit status 1","color":13574702,"timestamp":"2026-01-02T03:04:05Z",` +
+				`"fields":[{"name":"Job","value":"backup","inline":true},{"name":"Type","value":"shell","inline":true}]}]}`,
+		},
+		{
+			format: notifyPagerDuty,
+			token:  "routing-key",
+			want: `{"routing_key":"routing-key","event_action":"trigger","dedup_key":"easypanel-cron/backup","payload":{"summary":"Cron job \"backup\" (shell) failed: exit status 1","source":"backup","severity":"error","timestamp":"2026-01-02T03:04:05Z",` +
+				`"custom_details":{"text":"Cron job \"backup\" (shell) failed: exit status 1","content":"Cron job \"backup\" (shell) failed: exit status 1","job":"backup","type":"shell","error":"exit status 1","time":"2026-01-02T03:04:05Z"}}}`,
+		},
+	} {
+		t.Run(tc.format, func(t *testing.T) {
+			body, err := json.Marshal(notifySink{format: tc.format, token: tc.token}.payload(n))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want interface{}
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tc.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("payload = %s\nwant      %s", body, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// pauseGate implements START_PAUSED: the scheduler is set up but not started until
+// POST /resume. A nil gate means the runner was not started paused.
+type pauseGate struct {
+	once    sync.Once
+	resumed chan struct{}
+	// runID is the X-Correlation-Id of the POST /resume, if it had one. It is set
+	// before resumed is closed and only read after.
+	runID string
+}
+
+func newPauseGate(paused bool) *pauseGate {
+	if !paused {
+		return nil
+	}
+	return &pauseGate{resumed: make(chan struct{})}
+}
+
+// resume releases the gate, runID (if not "") becoming the correlation ID of the
+// runs the resume starts. It reports false if the runner is not (or no longer) paused.
+func (g *pauseGate) resume(runID string) bool {
+	if g == nil {
+		return false
+	}
+	ok := false
+	g.once.Do(func() {
+		g.runID = runID
+		close(g.resumed)
+		ok = true
+	})
+	return ok
+}
+
+// correlationID returns the correlation ID supplied with POST /resume for the
+// @startup and catch-up runs it starts, or "" for a new one per run.
+func (g *pauseGate) correlationID() string {
+	if g == nil || g.paused() {
+		return ""
+	}
+	return g.runID
+}
+
+// paused reports whether the runner is still waiting for POST /resume.
+func (g *pauseGate) paused() bool {
+	if g == nil {
+		return false
+	}
+	select {
+	case <-g.resumed:
+		return false
+	default:
+		return true
+	}
+}
+
+// wait blocks until the gate is released, returning false if a shutdown signal
+// arrives first. The signal is put back so the normal shutdown path sees it.
+func (g *pauseGate) wait(quit chan os.Signal) bool {
+	if g == nil {
+		return true
+	}
+	select {
+	case <-g.resumed:
+		return true
+	case sig := <-quit:
+		quit <- sig
+		return false
+	}
+}
+
+// waitStartupDelay implements STARTUP_DELAY: it blocks for delay before the scheduler
+// starts, returning false if a shutdown signal arrives first. The signal is put back
+// so the normal shutdown path sees it.
+func waitStartupDelay(logger *slog.Logger, delay time.Duration, quit chan os.Signal) bool {
+	if delay <= 0 {
+		return true
+	}
+	logger.Info("Delaying startup of the scheduler", "startup_delay", delay.String(), "scheduler_start", time.Now().Add(delay))
+	select {
+	case <-time.After(delay):
+		return true
+	case sig := <-quit:
+		quit <- sig
+		return false
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// pingTimeout bounds a monitoring ping so a slow monitoring service can't hold up jobs.
+const pingTimeout = 10 * time.Second
+
+// pingClient is used for monitoring pings, separate from the jobs' own http client.
+var pingClient = &http.Client{Timeout: pingTimeout}
+
+// sendStartPing signals the start of a run to CRON_START_PING_URL in the background.
+// Failures are only logged: a monitoring ping never affects the run itself.
+func sendStartPing(log *slog.Logger, target, correlationID string) {
+	if target == "" {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+		if err != nil {
+			log.Warn("Failed to create start ping", "error", err)
+			return
+		}
+		req.Header.Set(correlationHeader, correlationID)
+		resp, err := pingClient.Do(req)
+		if err != nil {
+			log.Warn("Start ping failed", "error", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			log.Warn("Start ping was rejected", "status", resp.Status)
+			return
+		}
+		log.Debug("Start ping sent", "status", resp.Status)
+	}()
+}
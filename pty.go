@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io"
+	"os/exec"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// runInPTY runs cmd with a pseudo-terminal as its stdin, stdout and stderr, and copies
+// everything written to the terminal to out. Like cmd.WaitDelay for pipes, it stops
+// reading at most WaitDelay after cmd exits, in case orphaned children keep the
+// terminal open.
+func runInPTY(cmd *exec.Cmd, out io.Writer) error {
+	ptmx, err := pty.Start(cmd)
+	if err != nil {
+		return err
+	}
+	defer ptmx.Close()
+
+	copied := make(chan struct{})
+	go func() {
+		// Reading fails with EIO once every process has closed the terminal.
+		io.Copy(out, ptmx)
+		close(copied)
+	}()
+
+	err = cmd.Wait()
+	select {
+	case <-copied:
+	case <-time.After(cmd.WaitDelay):
+		ptmx.Close()
+		<-copied
+	}
+	return err
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os/signal"
+	"reflect"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// scheduledJob is a job on the scheduler. Jobs are tracked by name so a SIGHUP reload
+// can reconcile the scheduler with the reloaded configuration.
+type scheduledJob struct {
+	config   Config
+	schedule cron.Schedule
+	job      cron.Job
+	probe    *driftProbe // holds the job's cron.EntryID once it is on the schedule
+	timer    *time.Timer // pending CRON_START_AFTER delay
+	breaker  *failureBreaker
+	flaps    *flapDetector
+	// execute runs the job once outside the schedule, started by the given trigger,
+	// with the caller's correlation ID or, if it is "", a new one.
+	execute func(trigger, runID string) error
+}
+
+// start puts the job on the scheduler. At startup a job with CRON_START_AFTER only
+// gets there once its delay has elapsed; a job added by a reload is only delayed if
+// that time is still ahead.
+func (j *scheduledJob) start(c *cron.Cron, logger *slog.Logger, startup bool) {
+	if j.schedule == nil {
+		// @startup jobs are run by main when the scheduler starts.
+		logger.Info("Startup job added, it runs the next time the runner starts", "job_name", j.config.Name)
+		return
+	}
+	if j.config.StartAfter > 0 && (startup || time.Since(processStart) < j.config.StartAfter) {
+		j.timer = scheduleAfterStart(c, logger, j.config, j.schedule, j.job, j.probe)
+		return
+	}
+	j.probe.register(c.Schedule(j.schedule, j.job))
+}
+
+// logNextRun logs when the job will first run. Jobs that aren't on the schedule yet
+// have logged their first run already.
+func (j *scheduledJob) logNextRun(c *cron.Cron, logger *slog.Logger) {
+	id := cron.EntryID(j.probe.id.Load())
+	if id == 0 {
+		return
+	}
+	entry := c.Entry(id)
+	fields := []interface{}{"job_name", j.config.Name, "schedule", j.config.Schedule, "entry_id", int(id)}
+	if entry.Next.IsZero() {
+		fields = append(fields, "next_run", "never")
+	} else {
+		fields = append(fields, "next_run", entry.Next, "next_run_in", time.Until(entry.Next).Round(time.Second).String())
+	}
+	logger.Info("Job scheduled", fields...)
+}
+
+// stopTimer cancels a pending CRON_START_AFTER delay.
+func (j *scheduledJob) stopTimer() {
+	if j.timer != nil {
+		j.timer.Stop()
+	}
+}
+
+// remove takes the job off the scheduler. A run in progress is not interrupted.
+func (j *scheduledJob) remove(c *cron.Cron) {
+	j.stopTimer()
+	j.breaker.stop()
+	if id := cron.EntryID(j.probe.id.Load()); id != 0 {
+		c.Remove(id)
+	}
+}
+
+// shutdownContext returns a context that is cancelled as soon as SIGINT or SIGTERM
+// arrives, not once the signal loop gets to it: a reload, and the RELOAD_CANARY runs
+// it waits for, hold that loop up.
+func shutdownContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+}
+
+// reconcileJobs brings the scheduled jobs in line with configs: jobs that are new are
+// added, jobs that are gone are removed and jobs whose configuration changed are
+// replaced, losing their run state (flap detection, log sampling and so on).
+// Unchanged jobs are kept as they are. With a canary (RELOAD_CANARY), a shell job
+// whose commands changed is only replaced if the canary run of its new configuration
+// succeeds. It returns the names of the jobs added and removed; a replaced job counts
+// as neither.
+func reconcileJobs(logger *slog.Logger, c *cron.Cron, jobs map[string]*scheduledJob, configs []Config, newJob func(Config) *scheduledJob, canary func(Config) error) (added, removed []string) {
+	var updated, kept, rejected []string
+	wanted := make(map[string]bool, len(configs))
+	for _, config := range configs {
+		wanted[config.Name] = true
+		current, exists := jobs[config.Name]
+		if exists && reflect.DeepEqual(current.config, config) {
+			kept = append(kept, config.Name)
+			continue
+		}
+		if exists && canary != nil && config.JobType == "shell" && shellCommandsChanged(current.config, config) {
+			if err := canary(config); err != nil {
+				logger.Error("Changed shell command failed its canary run, change rejected, keeping the current job", "job_name", config.Name, "error", err)
+				rejected = append(rejected, config.Name)
+				continue
+			}
+			logger.Info("Changed shell command passed its canary run", "job_name", config.Name)
+		}
+		j := newJob(config)
+		if j == nil {
+			// The job's schedule was rejected; a previous version keeps running.
+			continue
+		}
+		if exists {
+			current.remove(c)
+			updated = append(updated, config.Name)
+			logger.Info("Job configuration changed, rescheduling job", "job_name", config.Name, "schedule", config.Schedule)
+		} else {
+			added = append(added, config.Name)
+			logger.Info("Job added", "job_name", config.Name, "schedule", config.Schedule, "type", config.JobType)
+		}
+		jobs[config.Name] = j
+		j.start(c, logger, false)
+		j.logNextRun(c, logger)
+	}
+	for name, j := range jobs {
+		if wanted[name] {
+			continue
+		}
+		j.remove(c)
+		delete(jobs, name)
+		removed = append(removed, name)
+		logger.Info("Job removed", "job_name", name)
+	}
+	sort.Strings(removed)
+
+	logger.Info("Job configuration reloaded", "added", added, "removed", removed, "updated", updated, "kept", kept, "rejected", rejected, "job_count", len(jobs))
+	return added, removed
+}
+
+// shellCommandsChanged reports whether a reload changed what a shell job runs.
+func shellCommandsChanged(old, reloaded Config) bool {
+	return old.ShellCommand != reloaded.ShellCommand ||
+		old.ShellTargetContainer != reloaded.ShellTargetContainer ||
+		old.ShellPreCommand != reloaded.ShellPreCommand ||
+		old.ShellPostCommand != reloaded.ShellPostCommand ||
+		!reflect.DeepEqual(old.ShellTargets, reloaded.ShellTargets)
+}
+
+// reloadValidationTimeout bounds a RELOAD_VALIDATION_URL request.
+const reloadValidationTimeout = 10 * time.Second
+
+// validateReload implements RELOAD_VALIDATION_URL: it POSTs the reloaded
+// configurations, redacted, as a JSON array to url and reports whether the endpoint
+// approved them with a 2xx status. A request that fails counts as a rejection.
+func validateReload(logger *slog.Logger, url string, configs []Config) bool {
+	log := logger.With("reload_validation_url", redactURL(url))
+	redacted := make([]Config, len(configs))
+	for i, config := range configs {
+		redacted[i] = config.redacted()
+	}
+	body, err := json.Marshal(redacted)
+	if err != nil {
+		log.Error("Failed to encode the reloaded configuration for validation, keeping the current jobs", "error", err)
+		return false
+	}
+	client := &http.Client{Timeout: reloadValidationTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Error("Reload validation request failed, keeping the current jobs", "error", err)
+		return false
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.Error("Reload rejected by the validation endpoint, keeping the current jobs", "status", resp.Status)
+		return false
+	}
+	log.Info("Reload approved by the validation endpoint", "status", resp.Status)
+	return true
+}
+
+// jobsInOrder returns the scheduled jobs of configs, in configuration order.
+func jobsInOrder(jobs map[string]*scheduledJob, configs []Config) []*scheduledJob {
+	ordered := make([]*scheduledJob, 0, len(jobs))
+	for _, config := range configs {
+		if j := jobs[config.Name]; j != nil {
+			ordered = append(ordered, j)
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+func TestCanaryGivesUpOnShutdown(t *testing.T) {
+	stopping, stop := shutdownContext()
+	defer stop()
+
+	c := cron.New()
+	current := Config{Name: "backup", JobType: "shell", Schedule: "@daily", ShellCommand: "true", ShellInterpreter: "sh", ShellTimeout: time.Minute}
+	jobs := map[string]*scheduledJob{"backup": {config: current, probe: &driftProbe{c: c}}}
+	changed := current
+	changed.ShellCommand = "exec sleep 60"
+	canary := func(config Config) error {
+		return runShellJob(stopping, discardLog, config)
+	}
+	newJob := func(Config) *scheduledJob {
+		t.Error("the change was applied although its canary run was interrupted")
+		return nil
+	}
+
+	time.AfterFunc(100*time.Millisecond, func() {
+		syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	})
+	start := time.Now()
+	reconcileJobs(discardLog, c, jobs, []Config{changed}, newJob, canary)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("reload returned %s after SIGTERM, want it to give up on the canary run", elapsed)
+	}
+	if stopping.Err() == nil {
+		t.Error("SIGTERM did not cancel the shutdown context")
+	}
+	if got := jobs["backup"].config.ShellCommand; got != current.ShellCommand {
+		t.Errorf("job command = %q, want the current %q", got, current.ShellCommand)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// statusError is returned by an http job whose target answered with an error status.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("request failed with status %s", e.status)
+}
+
+// Retry classes of a failed http attempt.
+const (
+	retryConnection = "connection" // the request never reached the server: safe to retry
+	retryResponse   = "response"   // the server answered with a retried status: the request may have had effects
+)
+
+// retryClass classifies a failed http attempt, returning "" for failures that are
+// never retried (4xx responses, timeouts after the request was sent, ...). Error
+// statuses are retried if retryOn lists them or, without retryOn, if they are 5xx.
+func retryClass(err error, retryOn []statusRange) string {
+	var status *statusError
+	if errors.As(err, &status) {
+		retried := status.code >= 500
+		if retryOn != nil {
+			retried = statusAccepted(retryOn, status.code)
+		}
+		if retried {
+			return retryResponse
+		}
+		return ""
+	}
+
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var recordErr tls.RecordHeaderError
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	switch {
+	case errors.As(err, &dnsErr):
+		return retryConnection
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return retryConnection
+	case errors.As(err, &recordErr), errors.As(err, &certErr), errors.As(err, &unknownAuthority):
+		return retryConnection
+	}
+	return ""
+}
+
+// idempotentMethods can be repeated without changing the outcome (RFC 9110, 9.2.2).
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// retryDeclined returns why a failed http run may not be retried although retries
+// are left, or "" if it may. A request the server may have acted on is only repeated
+// for idempotent methods, unless CRON_RETRY_UNSAFE allows it, and with
+// CRON_RETRY_ON_STATUS an error status only if it is listed.
+func retryDeclined(config Config, err error) string {
+	var status *statusError
+	if config.RetryOnStatus != nil && errors.As(err, &status) && !statusAccepted(config.RetryOnStatus, status.code) {
+		return "status is not listed in CRON_RETRY_ON_STATUS"
+	}
+	if !config.RetryUnsafe && !idempotentMethods[config.HTTPMethod] && retryClass(err, config.RetryOnStatus) != retryConnection {
+		return "method is not idempotent and the server may have acted on the request (set CRON_RETRY_UNSAFE to retry anyway)"
+	}
+	return ""
+}
+
+// retryBudgetWindow is the rolling window CRON_MAX_RETRIES_PER_DAY counts retries in.
+const retryBudgetWindow = 24 * time.Hour
+
+// retryBudget caps the retries of a job across its runs (CRON_MAX_RETRIES_PER_DAY),
+// so a job failing all day doesn't keep hammering its target with retries. A nil
+// budget is unlimited.
+type retryBudget struct {
+	limit int
+
+	mu    sync.Mutex
+	taken []time.Time // when the retries within the window were made, oldest first
+}
+
+// newRetryBudget returns the budget of a job allowed limit retries per rolling 24h
+// window, or nil if limit is 0 (unlimited).
+func newRetryBudget(limit int) *retryBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &retryBudget{limit: limit}
+}
+
+// take records a retry and reports whether the budget allowed it. An exhausted
+// budget is logged; it recovers as the retries it counted leave the window.
+func (b *retryBudget) take(log *slog.Logger) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	expired := 0
+	for expired < len(b.taken) && now.Sub(b.taken[expired]) >= retryBudgetWindow {
+		expired++
+	}
+	b.taken = b.taken[expired:]
+	if len(b.taken) >= b.limit {
+		log.Warn("Retry budget exhausted, not retrying", "max_retries_per_day", b.limit, "budget_resets_at", b.taken[0].Add(retryBudgetWindow).Format(time.RFC3339))
+		return false
+	}
+	b.taken = append(b.taken, now)
+	return true
+}
+
+// maxRetryBackoff caps the doubling retry backoffs, which would otherwise overflow
+// after a few dozen retries and turn into a negative delay, i.e. none.
+const maxRetryBackoff = time.Hour
+
+// nextBackoff doubles backoff up to maxRetryBackoff. A backoff configured longer than
+// that is kept as it is.
+func nextBackoff(backoff time.Duration) time.Duration {
+	if backoff >= maxRetryBackoff {
+		return backoff
+	}
+	return min(2*backoff, maxRetryBackoff)
+}
+
+// retryDelay returns the wait before the n-th retry of a run: initial, doubled for
+// every earlier retry.
+func retryDelay(initial time.Duration, n int) time.Duration {
+	delay := initial
+	for i := 1; i < n; i++ {
+		delay = nextBackoff(delay)
+	}
+	return delay
+}
+
+// withHTTPRetries wraps a single http attempt with the job's connection-level
+// (CRON_CONN_RETRIES) and response-level (CRON_RESP_RETRIES) retry budgets. Each budget
+// has its own backoff, which doubles after every retry of that type, up to
+// maxRetryBackoff. Every retry is
+// also taken from the job's daily budget.
+func withHTTPRetries(config Config, budget *retryBudget, attempt func(ctx context.Context, log *slog.Logger) error) func(ctx context.Context, log *slog.Logger) error {
+	if config.ConnRetries == 0 && config.RespRetries == 0 {
+		return attempt
+	}
+	return func(ctx context.Context, log *slog.Logger) error {
+		left := map[string]int{retryConnection: config.ConnRetries, retryResponse: config.RespRetries}
+		backoff := map[string]time.Duration{retryConnection: config.ConnRetryBackoff, retryResponse: config.RespRetryBackoff}
+		for n := 1; ; n++ {
+			err := attempt(ctx, log)
+			class := retryClass(err, config.RetryOnStatus)
+			// A cancelled run (e.g. on shutdown) is not retried.
+			if err == nil || class == "" || left[class] == 0 || ctx.Err() != nil {
+				return err
+			}
+			if reason := retryDeclined(config, err); reason != "" {
+				log.Warn("Not retrying request", "retry_type", class, "method", config.HTTPMethod, "reason", reason, "error", err)
+				return err
+			}
+			if !budget.take(log) {
+				return err
+			}
+			delay := backoff[class]
+			left[class]--
+			backoff[class] = nextBackoff(backoff[class])
+			log.Warn("Retrying request", "retry_type", class, "attempt", n, "retries_left", left[class], "backoff", delay.String(), "error", err)
+
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(delay):
+			}
+		}
+	}
+}
+
+// withRetries retries a failed run up to config.Retries times, waiting
+// config.RetryBackoff * 2^(n-1), at most maxRetryBackoff, before the n-th retry. A
+// pending retry is abandoned when the run's context ends or stopping is cancelled (on
+// shutdown). Every retry is also taken from the job's daily budget.
+func withRetries(config Config, stopping context.Context, budget *retryBudget, run func(ctx context.Context, log *slog.Logger) error) func(ctx context.Context, log *slog.Logger) error {
+	if config.Retries == 0 {
+		return run
+	}
+	return func(ctx context.Context, log *slog.Logger) error {
+		err := run(ctx, log)
+		for n := 1; err != nil && n <= config.Retries; n++ {
+			if config.JobType == "http" {
+				if reason := retryDeclined(config, err); reason != "" {
+					log.Warn("Not retrying job", "method", config.HTTPMethod, "reason", reason, "error", err)
+					return err
+				}
+			}
+			if !budget.take(log) {
+				return err
+			}
+			delay := retryDelay(config.RetryBackoff, n)
+			log.Warn("Job failed, retrying", "attempt", n, "max_retries", config.Retries, "delay", delay.String(), "error", err)
+			select {
+			case <-ctx.Done():
+				return err
+			case <-stopping.Done():
+				log.Warn("Shutting down, pending retry cancelled", "attempt", n)
+				return err
+			case <-time.After(delay):
+			}
+			err = run(ctx, log)
+		}
+		return err
+	}
+}
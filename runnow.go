@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+)
+
+// skippedError is returned by a run that never started (its job was disabled, the
+// runner was shedding load, ...). It wraps the skip reason.
+type skippedError struct {
+	reason error
+}
+
+func (e *skippedError) Error() string {
+	return e.reason.Error()
+}
+
+func (e *skippedError) Unwrap() error {
+	return e.reason
+}
+
+// exitCodes maps the outcome of a RUN_NOW run to the process exit code.
+type exitCodes struct {
+	// failure is EXIT_CODE_ON_FAILURE, skip is EXIT_CODE_ON_SKIP.
+	failure, skip int
+	// propagate is PROPAGATE_EXIT_CODE: a failed shell command's own exit code
+	// takes precedence over failure.
+	propagate bool
+}
+
+func newExitCodesFromEnv(logger *slog.Logger) exitCodes {
+	codes := exitCodes{failure: envExitCode(logger, "EXIT_CODE_ON_FAILURE", 1), propagate: envBool("PROPAGATE_EXIT_CODE")}
+	codes.skip = envExitCode(logger, "EXIT_CODE_ON_SKIP", codes.failure)
+	return codes
+}
+
+// envExitCode parses the environment variable key as a process exit code (0-255).
+// Unset or invalid values fall back to def; invalid values are logged.
+func envExitCode(logger *slog.Logger, key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > 255 {
+		logger.Error("Invalid "+key+", using default", "value", v, "default", def)
+		return def
+	}
+	return n
+}
+
+// of returns the exit code for the outcome err of a run.
+func (c exitCodes) of(err error) int {
+	var skipped *skippedError
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &skipped):
+		return c.skip
+	case c.propagate && errors.As(err, &exitErr) && exitErr.ExitCode() > 0:
+		return exitErr.ExitCode()
+	}
+	return c.failure
+}
+
+// runNow implements RUN_NOW: the named job runs once, right away, and the scheduler
+// is never started. It returns the process exit code: 0 on success, otherwise as
+// mapped by codes. A name that matches no job exits with 1. runID, from
+// RUN_NOW_CORRELATION_ID, is the run's correlation ID if set.
+func runNow(logger *slog.Logger, jobs map[string]*scheduledJob, name, runID string, codes exitCodes) int {
+	if runID != "" && !validCorrelationID(runID) {
+		logger.Error("Invalid RUN_NOW_CORRELATION_ID: must be at most 128 printable characters without spaces", "job_name", name)
+		return 1
+	}
+	j, ok := jobs[name]
+	if !ok {
+		names := make([]string, 0, len(jobs))
+		for configured := range jobs {
+			names = append(names, configured)
+		}
+		sort.Strings(names)
+		logger.Error("RUN_NOW does not name a configured job", "job_name", name, "jobs", names)
+		return 1
+	}
+	logger.Info("Running job once, RUN_NOW is set", "job_name", name)
+	err := j.execute(auditTriggerManual, runID)
+	code := codes.of(err)
+	var skipped *skippedError
+	switch {
+	case err == nil:
+		logger.Info("Manual run succeeded", "job_name", name)
+	case errors.As(err, &skipped):
+		logger.Warn("Manual run skipped", "job_name", name, "reason", err, "exit_code", code)
+	default:
+		logger.Error("Manual run failed", "job_name", name, "error", err, "exit_code", code)
+	}
+	return code
+}
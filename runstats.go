@@ -0,0 +1,102 @@
+package main
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// jobRunStats are the counts of one job's completed runs and the outcome of the latest.
+type jobRunStats struct {
+	runs, successes, failures int
+	slow                      int // successful runs over CRON_SLOW_THRESHOLD
+	skipped                   int // runs that didn't start, e.g. under CRON_OVERLAP_POLICY=skip
+	lastRun                   time.Time
+	lastSuccess, lastSlow     bool
+	lastError                 string
+}
+
+// runStats counts the completed runs of every job, for GET /jobs and the summary
+// logged at shutdown.
+type runStats struct {
+	mu   sync.Mutex
+	jobs map[string]*jobRunStats
+}
+
+func newRunStats() *runStats {
+	return &runStats{jobs: make(map[string]*jobRunStats)}
+}
+
+// observe counts the run described by rec.
+func (s *runStats) observe(rec runRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.jobs[rec.Job]
+	if stats == nil {
+		stats = &jobRunStats{}
+		s.jobs[rec.Job] = stats
+	}
+	stats.runs++
+	if rec.Success {
+		stats.successes++
+	} else {
+		stats.failures++
+	}
+	stats.lastRun = rec.StartedAt
+	if rec.Slow {
+		stats.slow++
+	}
+	stats.lastSuccess, stats.lastError, stats.lastSlow = rec.Success, rec.Error, rec.Slow
+}
+
+// observeSkip counts a run of the job name that didn't start.
+func (s *runStats) observeSkip(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.jobs[name]
+	if stats == nil {
+		stats = &jobRunStats{}
+		s.jobs[name] = stats
+	}
+	stats.skipped++
+}
+
+// get returns the stats of the job name, zero if it hasn't completed a run.
+func (s *runStats) get(name string) jobRunStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stats := s.jobs[name]; stats != nil {
+		return *stats
+	}
+	return jobRunStats{}
+}
+
+// logSummary logs one line per job with its run counts. configured names the jobs
+// scheduled at shutdown, so those that never ran are listed too.
+func (s *runStats) logSummary(logger *slog.Logger, configured []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.jobs)+len(configured))
+	for name := range s.jobs {
+		names = append(names, name)
+	}
+	for _, name := range configured {
+		if s.jobs[name] == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		stats := s.jobs[name]
+		if stats == nil {
+			stats = &jobRunStats{}
+		}
+		fields := []interface{}{"job_name", name, "runs", stats.runs, "successes", stats.successes, "failures", stats.failures, "skipped", stats.skipped}
+		if !stats.lastRun.IsZero() {
+			fields = append(fields, "last_run", stats.lastRun)
+		}
+		logger.Info("Run summary", fields...)
+	}
+}
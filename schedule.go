@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// scheduleAtStartup is the CRON_SCHEDULE of jobs that run once, when the scheduler
+// starts, instead of on a schedule.
+const scheduleAtStartup = "@startup"
+
+// secondsParser parses the 6-field schedules of CRON_WITH_SECONDS, like the parser
+// of cron.WithSeconds.
+var secondsParser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// parseSchedule parses the schedule of config: a standard 5-field expression, or one
+// with a leading seconds field under CRON_WITH_SECONDS, evaluated in the job's
+// CRON_TZ if it has one.
+func parseSchedule(config Config) (cron.Schedule, error) {
+	spec := config.Schedule
+	if config.Timezone != "" {
+		// The parser's own time zone prefix; the name was validated with the config.
+		spec = "CRON_TZ=" + config.Timezone + " " + spec
+	}
+	if envBool("CRON_WITH_SECONDS") {
+		return secondsParser.Parse(spec)
+	}
+	return cron.ParseStandard(spec)
+}
+
+// warnIfScheduleNeverFires logs a prominent warning for schedules that are valid but
+// never fire (e.g. "0 0 30 2 *") or whose next run is beyond horizon, and reports
+// whether it did.
+func warnIfScheduleNeverFires(logger *slog.Logger, config Config, schedule cron.Schedule, horizon time.Duration) bool {
+	now := time.Now()
+	next := schedule.Next(now)
+	switch {
+	case next.IsZero():
+		logger.Warn("Job schedule never fires, this job will not run", "job_name", config.Name, "schedule", config.Schedule)
+		return true
+	case horizon > 0 && next.Sub(now) > horizon:
+		logger.Warn("Job schedule does not fire within the horizon, this job may never run", "job_name", config.Name, "schedule", config.Schedule, "next_run", next, "horizon", horizon.String())
+		return true
+	}
+	return false
+}
+
+// countNeverFiring returns how many of configs have a schedule that never fires or
+// not within horizon, each of which is logged. STRICT_CONFIG counts them as invalid.
+func countNeverFiring(logger *slog.Logger, configs []Config, horizon time.Duration) int {
+	n := 0
+	for _, config := range configs {
+		if config.Schedule == scheduleAtStartup {
+			continue
+		}
+		// The schedules of loaded configurations have been validated already.
+		if schedule, err := parseSchedule(config); err == nil && warnIfScheduleNeverFires(logger, config, schedule, horizon) {
+			n++
+		}
+	}
+	return n
+}
+
+// CRON_OVERLAP_POLICY values.
+const (
+	overlapAllow = "allow"
+	overlapSkip  = "skip"
+	overlapDelay = "delay"
+)
+
+// errStillRunning is the skip reason of runs dropped by CRON_OVERLAP_POLICY=skip.
+var errStillRunning = errors.New("previous run still in progress")
+
+// overlapGuard implements CRON_OVERLAP_POLICY for the runs of one job. It is checked
+// inside the run, so a skipped run is reported like any other skip and a run that
+// panics still releases the job for the next tick.
+type overlapGuard struct {
+	running chan struct{}
+}
+
+// enter starts a run under policy: with overlapSkip it returns errStillRunning while
+// the previous run is in progress, with overlapDelay it waits for that run to finish
+// or ctx to be done. The returned function ends the run.
+func (g *overlapGuard) enter(ctx context.Context, log *slog.Logger, policy string) (func(), error) {
+	if policy != overlapSkip && policy != overlapDelay {
+		return func() {}, nil
+	}
+	select {
+	case g.running <- struct{}{}:
+		return g.leave, nil
+	default:
+	}
+	if policy == overlapSkip {
+		return nil, errStillRunning
+	}
+	waitStart := time.Now()
+	select {
+	case g.running <- struct{}{}:
+		log.Info("Run was delayed until the previous run finished", "overlap_wait", time.Since(waitStart).Round(time.Millisecond).String())
+		return g.leave, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (g *overlapGuard) leave() {
+	<-g.running
+}
+
+// overlapGuards hands out the overlapGuard of each job name, so the version of a job
+// a reload replaced and its successor don't overlap either.
+type overlapGuards struct {
+	mu     sync.Mutex
+	guards map[string]*overlapGuard
+}
+
+func newOverlapGuards() *overlapGuards {
+	return &overlapGuards{guards: make(map[string]*overlapGuard)}
+}
+
+// get returns the guard of the job name.
+func (o *overlapGuards) get(name string) *overlapGuard {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	g := o.guards[name]
+	if g == nil {
+		g = &overlapGuard{running: make(chan struct{}, 1)}
+		o.guards[name] = g
+	}
+	return g
+}
+
+// scheduleAfterStart runs job once when config.StartAfter has elapsed since process
+// start and only then adds its recurring schedule. The returned timer is stopped on
+// shutdown so a delayed job doesn't start while the scheduler is stopping.
+func scheduleAfterStart(c *cron.Cron, logger *slog.Logger, config Config, schedule cron.Schedule, job cron.Job, probe *driftProbe) *time.Timer {
+	delay := config.StartAfter - time.Since(processStart)
+	logger.Info("Delaying first run of job", "job_name", config.Name, "start_after", config.StartAfter.String(), "first_run", time.Now().Add(delay))
+	return time.AfterFunc(delay, func() {
+		probe.register(c.Schedule(schedule, job))
+		logger.Info("Start delay elapsed, running job and activating its schedule", "job_name", config.Name, "next_run", schedule.Next(time.Now()))
+		// The first run happens outside the scheduler, so it needs its own panic recovery.
+		cron.Recover(SlogCronLogger{Logger: logger})(job).Run()
+	})
+}
+
+// driftProbe measures how late the scheduler started a job's runs, which reveals an
+// overloaded host.
+type driftProbe struct {
+	c  *cron.Cron
+	id atomic.Int64 // cron.EntryID, 0 until the job is on the schedule
+}
+
+func (p *driftProbe) register(id cron.EntryID) {
+	p.id.Store(int64(id))
+}
+
+// drift returns how long after its scheduled fire time the current run started. ok
+// is false for runs the scheduler didn't start, such as a CRON_START_AFTER first run.
+//
+// It relies on the scheduler recording an entry's fire time as Prev before it serves
+// the entry snapshot a job can ask for.
+func (p *driftProbe) drift(started time.Time) (d time.Duration, ok bool) {
+	id := p.id.Load()
+	if id == 0 {
+		return 0, false
+	}
+	prev := p.c.Entry(cron.EntryID(id)).Prev
+	if prev.IsZero() || started.Before(prev) {
+		return 0, false
+	}
+	return started.Sub(prev), true
+}
+
+// sleepJitter waits a random duration below jitter before a run of a job with
+// CRON_JITTER. It returns ctx's error if ctx ends first.
+func sleepJitter(ctx context.Context, log *slog.Logger, jitter time.Duration) error {
+	if jitter <= 0 {
+		return nil
+	}
+	delay := time.Duration(rand.Int63n(int64(jitter)))
+	log.Debug("Delaying run by a random jitter", "delay", delay.String(), "jitter", jitter.String())
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOverlapSkipReportsSkippedRun(t *testing.T) {
+	g := newOverlapGuards().get("backup")
+	leave, err := g.enter(context.Background(), discardLog, overlapSkip)
+	if err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+
+	_, err = g.enter(context.Background(), discardLog, overlapSkip)
+	if !errors.Is(err, errStillRunning) {
+		t.Fatalf("overlapping run: err = %v, want %v", err, errStillRunning)
+	}
+	var out bytes.Buffer
+	events := &eventStream{w: &out, logger: discardLog}
+	events.emit(jobSkippedEvent(Config{Name: "backup", JobType: "shell"}, "run-1", err))
+	var e event
+	if err := json.Unmarshal(out.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Event != eventJobSkipped || e.Job != "backup" || e.Reason != errStillRunning.Error() {
+		t.Errorf("event = %+v, want a job_skipped event of backup with reason %q", e, errStillRunning)
+	}
+
+	leave()
+	if leave, err = g.enter(context.Background(), discardLog, overlapSkip); err != nil {
+		t.Fatalf("run after the previous one finished: %v", err)
+	}
+	leave()
+}
+
+func TestOverlapDelayWaitsForPreviousRun(t *testing.T) {
+	g := newOverlapGuards().get("backup")
+	leave, err := g.enter(context.Background(), discardLog, overlapDelay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entered := make(chan struct{})
+	go func() {
+		if leave, err := g.enter(context.Background(), discardLog, overlapDelay); err == nil {
+			leave()
+		}
+		close(entered)
+	}()
+	select {
+	case <-entered:
+		t.Fatal("delayed run started while the previous run was in progress")
+	case <-time.After(20 * time.Millisecond):
+	}
+	leave()
+	select {
+	case <-entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("delayed run did not start after the previous run finished")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	leave, _ = g.enter(context.Background(), discardLog, overlapDelay)
+	defer leave()
+	if _, err := g.enter(ctx, discardLog, overlapDelay); !errors.Is(err, context.Canceled) {
+		t.Errorf("delayed run after cancel: err = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestOverlapAllowDoesNotWait(t *testing.T) {
+	g := newOverlapGuards().get("backup")
+	for i := 0; i < 2; i++ {
+		if _, err := g.enter(context.Background(), discardLog, overlapAllow); err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+	}
+}
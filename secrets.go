@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileVersion identifies the contents a watched file had when it was last read.
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+	sum     [sha256.Size]byte
+}
+
+// secretFiles picks up secret files (CRON_SECRET_FILE, ...) and mTLS files
+// (CRON_CA_FILE, CRON_CLIENT_CERT, CRON_CLIENT_KEY) that are rotated in place, e.g.
+// a Kubernetes secret, so a long-running runner keeps working without a restart.
+// The files are checked before every request: by mtime and size, and if those
+// changed, by content hash.
+type secretFiles struct {
+	shared *http.Client
+	config Config
+
+	mu       sync.Mutex
+	client   *http.Client
+	secrets  map[string]string // secret setting -> its current value
+	versions map[string]fileVersion
+}
+
+// newSecretFiles returns the watcher of an http job's files, which currently uses
+// client, or nil if the job reads none of them from files.
+func newSecretFiles(shared, client *http.Client, config Config) *secretFiles {
+	if len(config.SecretFiles) == 0 && config.CAFile == "" && config.ClientCert == "" {
+		return nil
+	}
+	s := &secretFiles{
+		shared:   shared,
+		config:   config,
+		client:   client,
+		secrets:  make(map[string]string),
+		versions: make(map[string]fileVersion),
+	}
+	for _, path := range s.tlsFiles() {
+		s.changed(path)
+	}
+	for _, path := range config.SecretFiles {
+		s.changed(path)
+	}
+	return s
+}
+
+// tlsFiles returns the job's mTLS files.
+func (s *secretFiles) tlsFiles() []string {
+	var paths []string
+	for _, path := range []string{s.config.CAFile, s.config.ClientCert, s.config.ClientKey} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// changed reports whether the contents of path differ from when it was last read,
+// returning the new contents if so.
+func (s *secretFiles) changed(path string) ([]byte, bool, error) {
+	version := s.versions[path]
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false, err
+	}
+	if info.ModTime().Equal(version.modTime) && info.Size() == version.size {
+		return nil, false, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	sum := sha256.Sum256(data)
+	s.versions[path] = fileVersion{modTime: info.ModTime(), size: info.Size(), sum: sum}
+	return data, !bytes.Equal(sum[:], version.sum[:]), nil
+}
+
+// apply reloads the files that changed since the previous request and returns
+// config with the current secrets, and the client to send the request with. A file
+// that can't be reloaded keeps its previous contents and is tried again next time.
+func (s *secretFiles) apply(log *slog.Logger, config Config, client *http.Client) (Config, *http.Client) {
+	if s == nil {
+		return config, client
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for setting, path := range config.SecretFiles {
+		data, changed, err := s.changed(path)
+		switch {
+		case err != nil:
+			log.Warn("Failed to reload secret, keeping the previous one", "setting", setting, "path", path, "error", err)
+		case changed:
+			s.secrets[setting] = strings.TrimRight(string(data), "\r\n")
+			log.Info("Reloaded secret", "setting", setting, "source", "file:"+path)
+		}
+	}
+
+	var rotated []string
+	for _, path := range s.tlsFiles() {
+		if _, changed, err := s.changed(path); err != nil {
+			log.Warn("Failed to check TLS file for changes", "path", path, "error", err)
+		} else if changed {
+			rotated = append(rotated, path)
+		}
+	}
+	if len(rotated) > 0 {
+		if reloaded, err := jobHTTPClient(s.shared, s.config); err != nil {
+			log.Error("Failed to reload TLS files, keeping the previous ones", "files", rotated, "error", err)
+			// A certificate and its key are rarely replaced at the same instant: try
+			// again on the next request rather than waiting for another change.
+			for _, path := range rotated {
+				delete(s.versions, path)
+			}
+		} else {
+			s.client.CloseIdleConnections()
+			s.client = reloaded
+			log.Info("Reloaded TLS files", "files", rotated)
+		}
+	}
+
+	for setting, v := range s.secrets {
+		switch setting {
+		case "CRON_SECRET":
+			config.SecretToken = v
+		case "CRON_AUTH_PASS":
+			config.AuthPass = v
+		case "CRON_HMAC_SECRET":
+			config.HMACSecret = v
+		}
+	}
+	return config, s.client
+}
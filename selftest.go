@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// selfTestDNSTimeout bounds each DNS lookup of the self-test.
+const selfTestDNSTimeout = 5 * time.Second
+
+// selfTest implements SELF_TEST: it loads the configuration, checks that everything
+// the configured jobs depend on is present and logs a pass/fail summary. It returns
+// the process exit code, non-zero if any check failed or any job is invalid.
+func selfTest(logger *slog.Logger) int {
+	logger.Info("Running self-test")
+	dockerAvailable = detectDocker(logger)
+	configs, invalid := loadConfigs(logger)
+	if !resolveDuplicateNames(logger, configs, envBool("STRICT_CONFIG")) {
+		invalid++
+	}
+
+	passed, failed := 0, 0
+	check := func(name string, config *Config, err error) {
+		fields := []interface{}{"check", name}
+		if config != nil {
+			fields = append(fields, "job_name", config.Name)
+		}
+		if err != nil {
+			failed++
+			logger.Error("Self-test check failed", append(fields, "error", err)...)
+			return
+		}
+		passed++
+		logger.Info("Self-test check passed", fields...)
+	}
+
+	for i := range configs {
+		config := &configs[i]
+		if config.Schedule != scheduleAtStartup {
+			schedule, err := parseSchedule(*config)
+			if err == nil && schedule.Next(time.Now()).IsZero() {
+				err = fmt.Errorf("schedule %q never fires", config.Schedule)
+			}
+			check("schedule", config, err)
+		}
+		switch config.JobType {
+		case "http":
+			for _, target := range config.TargetURLs {
+				targetURL, err := renderTemplate(target, jobNow(*config), config.Name)
+				if err == nil {
+					err = resolveURLHost(targetURL)
+				}
+				check("dns", config, err)
+			}
+		case "cert_expiry":
+			host, _, _ := net.SplitHostPort(config.CertHost)
+			check("dns", config, resolveHost(host))
+		case "shell":
+			if config.usesDockerExec() {
+				check("docker", config, dockerCheck())
+			} else {
+				_, err := exec.LookPath(config.ShellInterpreter)
+				check("shell", config, err)
+			}
+		case "docker_run":
+			check("docker", config, dockerCheck())
+		}
+	}
+	for _, key := range []string{"DEAD_LETTER_FILE", "CONDITIONAL_CACHE_FILE", "STATE_FILE"} {
+		if path := os.Getenv(key); path != "" {
+			check(key, nil, dirExists(path))
+		}
+	}
+
+	fields := []interface{}{"jobs", len(configs), "invalid_jobs", invalid, "passed", passed, "failed", failed}
+	if failed > 0 || invalid > 0 || len(configs) == 0 {
+		logger.Error("Self-test failed", fields...)
+		return 1
+	}
+	logger.Info("Self-test passed", fields...)
+	return 0
+}
+
+func dockerCheck() error {
+	if !dockerAvailable {
+		return fmt.Errorf("docker CLI or socket not available")
+	}
+	return nil
+}
+
+func resolveURLHost(target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("invalid target URL: %w", err)
+	}
+	return resolveHost(u.Hostname())
+}
+
+// resolveHost checks that host resolves. IP addresses need no lookup.
+func resolveHost(host string) error {
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestDNSTimeout)
+	defer cancel()
+	_, err := net.DefaultResolver.LookupHost(ctx, host)
+	return err
+}
+
+// dirExists checks that the directory a runner file is written to exists.
+func dirExists(path string) error {
+	dir := filepath.Dir(path)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shellTarget is one container/command pair of a SHELL_TARGETS job.
+type shellTarget struct {
+	Container string
+	Command   string
+}
+
+// parseShellTargets parses a SHELL_TARGETS value: one "container: command" entry per
+// line (or separated by ";;"). Only the first colon separates the container name, so
+// commands may contain colons themselves.
+func parseShellTargets(v string) ([]shellTarget, error) {
+	var targets []shellTarget
+	for _, entry := range splitList(v) {
+		container, command, ok := strings.Cut(entry, ":")
+		container, command = strings.TrimSpace(container), strings.TrimSpace(command)
+		if !ok || container == "" || command == "" {
+			return nil, fmt.Errorf("entry %q must be of the form \"container: command\"", entry)
+		}
+		if strings.ContainsAny(container, " \t") {
+			return nil, fmt.Errorf("container name %q must not contain whitespace", container)
+		}
+		targets = append(targets, shellTarget{Container: container, Command: command})
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("no targets defined")
+	}
+	return targets, nil
+}
+
+// checkCommandAllowed returns an error unless the first token of command is permitted
+// by allowlist, as parsed from SHELL_COMMAND_ALLOWLIST. An entry ending in "/" permits
+// every binary below that directory; any other entry must match the token exactly.
+// An empty allowlist permits everything.
+func checkCommandAllowed(allowlist []string, command string) error {
+	if len(allowlist) == 0 {
+		return nil
+	}
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil
+	}
+	binary := fields[0]
+	for _, entry := range allowlist {
+		if binary == entry {
+			return nil
+		}
+		if strings.HasSuffix(entry, "/") && strings.HasPrefix(binary, entry) && !strings.Contains(binary, "..") {
+			return nil
+		}
+	}
+	return fmt.Errorf("command %q is not permitted by SHELL_COMMAND_ALLOWLIST", binary)
+}
+
+// varRef matches a ${NAME} reference for SHELL_EXPAND_VARS, or its $${NAME} escape.
+var varRef = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandVars replaces the ${NAME} references in command with their value from env
+// (KEY=VALUE entries, the last one winning) or else the process environment.
+// $${NAME} becomes a literal ${NAME}, and anything else, including $NAME and
+// ${NAME:-default}, is left to the shell. Undefined variables are an error.
+func expandVars(command string, env []string) (string, error) {
+	var missing []string
+	expanded := varRef.ReplaceAllStringFunc(command, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		name := ref[2 : len(ref)-1]
+		for i := len(env) - 1; i >= 0; i-- {
+			if key, value, _ := strings.Cut(env[i], "="); key == name {
+				return value
+			}
+		}
+		if value, ok := os.LookupEnv(name); ok {
+			return value
+		}
+		missing = append(missing, name)
+		return ref
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("undefined variables: %s", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// maskEnvValues replaces the values of env's KEY=VALUE entries in s with "***".
+func maskEnvValues(s string, env []string) string {
+	for _, e := range env {
+		if _, value, _ := strings.Cut(e, "="); value != "" {
+			s = strings.ReplaceAll(s, value, "***")
+		}
+	}
+	return s
+}
+
+// splitList splits a multi-valued setting into its entries: one per line, or separated
+// by ";;" on a single line. Empty entries are dropped.
+func splitList(v string) []string {
+	var entries []string
+	for _, line := range strings.Split(v, "\n") {
+		for _, entry := range strings.Split(line, ";;") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries
+}
+
+// envEntryStart matches the KEY= that starts a SHELL_ENV entry.
+var envEntryStart = regexp.MustCompile(`^\s*[A-Za-z_][A-Za-z0-9_]*=`)
+
+// parseShellEnv splits a SHELL_ENV value into its KEY=VALUE entries: one per line,
+// separated by ";;" or by commas, e.g. "PGPASSWORD=secret,API_BASE=https://api". A
+// comma only separates entries when a KEY= follows it, so "HOSTS=a,b" stays one entry.
+func parseShellEnv(v string) []string {
+	var entries []string
+	for _, entry := range splitList(v) {
+		parts := strings.Split(entry, ",")
+		merged := []string{parts[0]}
+		for _, part := range parts[1:] {
+			if envEntryStart.MatchString(part) {
+				merged = append(merged, part)
+				continue
+			}
+			merged[len(merged)-1] += "," + part
+		}
+		for _, part := range merged {
+			if part = strings.TrimSpace(part); part != "" {
+				entries = append(entries, part)
+			}
+		}
+	}
+	return entries
+}
+
+// compilePatterns compiles a list of regular expressions as parsed by splitList.
+func compilePatterns(v string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, expr := range splitList(v) {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", expr, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// redact replaces every match of patterns in s with "***".
+func redact(s string, patterns []*regexp.Regexp) string {
+	for _, re := range patterns {
+		s = re.ReplaceAllString(s, "***")
+	}
+	return s
+}
+
+// quoteArgs renders argv as a single POSIX shell command line, single-quoting any
+// argument that the shell would otherwise split or expand.
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=@%+,") == "" {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// runShellTargets runs every target's command, sequentially or concurrently, and
+// fails if any of them fails. Each target's outcome is logged separately.
+func runShellTargets(ctx context.Context, log *slog.Logger, config Config) error {
+	targets := config.ShellTargets
+	errs := make([]error, len(targets))
+	runOne := func(i int) {
+		t := targets[i]
+		tlog := log.With("target", i+1)
+		if err := runShellCommand(ctx, tlog, config, t.Container, t.Command); err != nil {
+			errs[i] = fmt.Errorf("target %d (%s): %w", i+1, t.Container, err)
+			tlog.Error("Target failed", "target_container", t.Container, "error", err)
+			return
+		}
+		tlog.Info("Target completed successfully", "target_container", t.Container)
+	}
+
+	if config.ShellTargetsParallel {
+		var wg sync.WaitGroup
+		for i := range targets {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				runOne(i)
+			}(i)
+		}
+		wg.Wait()
+	} else {
+		for i := range targets {
+			runOne(i)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// runShellJob runs a shell job once, within its SHELL_TIMEOUT: its SHELL_PRE command,
+// its command (or the commands of its SHELL_TARGETS) and its SHELL_POST command.
+func runShellJob(ctx context.Context, log *slog.Logger, config Config) error {
+	ctx, cancel := context.WithTimeout(ctx, config.ShellTimeout)
+	defer cancel()
+
+	err := runShellPhases(ctx, log, config, func(ctx context.Context, log *slog.Logger) error {
+		if len(config.ShellTargets) > 0 {
+			return runShellTargets(ctx, log, config)
+		}
+		return runShellCommand(ctx, log, config, config.ShellTargetContainer, config.ShellCommand)
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("Job completed successfully")
+	return nil
+}
+
+// runShellPhases runs a shell job's SHELL_PRE command, its main commands and its
+// SHELL_POST command, logging each with a phase field. The main commands are skipped
+// if the pre-command fails; the post-command runs regardless, like a defer, in the same
+// timeout as the rest. The job fails if any phase fails.
+func runShellPhases(ctx context.Context, log *slog.Logger, config Config, main func(ctx context.Context, log *slog.Logger) error) error {
+	if config.ShellPreCommand == "" && config.ShellPostCommand == "" {
+		return main(ctx, log)
+	}
+
+	var err error
+	if config.ShellPreCommand != "" {
+		if preErr := runShellCommand(ctx, log.With("phase", "pre"), config, config.ShellTargetContainer, config.ShellPreCommand); preErr != nil {
+			log.Error("Pre-command failed, skipping the main command", "phase", "pre", "error", preErr)
+			err = fmt.Errorf("pre-command: %w", preErr)
+		}
+	}
+	if err == nil {
+		err = main(ctx, log.With("phase", "main"))
+	}
+	if config.ShellPostCommand != "" {
+		if postErr := runShellCommand(ctx, log.With("phase", "post"), config, config.ShellTargetContainer, config.ShellPostCommand); postErr != nil {
+			log.Error("Post-command failed", "phase", "post", "error", postErr)
+			err = errors.Join(err, fmt.Errorf("post-command: %w", postErr))
+		}
+	}
+	return err
+}
+
+// runShellCommand runs command with the job's SHELL_INTERPRETER -c, locally when container is empty or inside
+// container via docker exec, and logs whatever it writes to stdout and stderr after
+// applying the job's redaction patterns.
+func runShellCommand(ctx context.Context, log *slog.Logger, config Config, container, command string) error {
+	var cmd *exec.Cmd
+	command, err := renderCommand(config, command)
+	if err != nil {
+		log.Error("Failed to render the shell command", "error", err)
+		return err
+	}
+	logFields := []interface{}{"command", command}
+	if config.ShellExpandVars {
+		expanded, err := expandVars(command, config.ShellEnv)
+		if err != nil {
+			log.Error("Failed to expand variables in the shell command", "error", err)
+			return err
+		}
+		command = expanded
+	}
+
+	if container == "" {
+		log.Info("Executing local shell command")
+		cmd = exec.CommandContext(ctx, config.ShellInterpreter, "-c", command)
+		cmd.Dir = config.ShellWorkdir
+		if len(config.ShellEnv) > 0 {
+			cmd.Env = append(os.Environ(), config.ShellEnv...)
+		}
+	} else {
+		logFields = append(logFields, "target_container", container)
+		log.Info("Executing remote shell command via docker exec", logFields...)
+		args := []string{"exec"}
+		if config.ShellAllocatePTY {
+			// docker allocates the terminal inside the container.
+			args = append(args, "-t")
+		}
+		if config.ShellDockerUser != "" {
+			args = append(args, "-u", config.ShellDockerUser)
+		}
+		if config.ShellDockerWorkdir != "" {
+			args = append(args, "-w", config.ShellDockerWorkdir)
+		}
+		for _, env := range config.ShellEnv {
+			args = append(args, "-e", env)
+		}
+		cmd = exec.CommandContext(ctx, "docker", append(args, container, config.ShellInterpreter, "-c", command)...)
+	}
+
+	// The assembled invocation (including any docker exec flags) helps reproduce a
+	// failure by hand; it goes through the same redaction as the output.
+	log.Debug("Resolved shell invocation", "command_line", redact(maskEnvValues(quoteArgs(cmd.Args), config.ShellEnv), config.ShellRedactPatterns))
+	// Don't let orphaned children of a killed command, which still hold the output
+	// pipes, hold up the timeout.
+	cmd.WaitDelay = 10 * time.Second
+	if container == "" {
+		if config.ShellAllocatePTY {
+			return runLoggedPTY(ctx, log, config, cmd)
+		}
+		return runLogged(ctx, log, config, cmd)
+	}
+
+	release, err := acquireDockerExecSlot(ctx, log)
+	if err != nil {
+		return err
+	}
+	if config.ShellWaitForContainer > 0 {
+		return runLoggedWaiting(ctx, log, config, container, cmd, release)
+	}
+	defer release()
+	return runLogged(ctx, log, config, cmd)
+}
+
+// acquireDockerExecSlot waits for one of the MAX_CONCURRENT_DOCKER_EXEC slots.
+func acquireDockerExecSlot(ctx context.Context, log *slog.Logger) (func(), error) {
+	release, err := dockerExecSlots.acquire(ctx, log, "docker exec")
+	if err != nil {
+		log.Error("Gave up waiting for a docker exec slot", "error", err)
+	}
+	return release, err
+}
+
+// runLoggedWaiting is runLogged for docker exec commands with SHELL_WAIT_FOR_CONTAINER:
+// if docker exec fails because the target container is not running (e.g. while it
+// restarts), it waits for the container to run again and makes one more attempt.
+// It takes over release, the command's docker exec slot, which is given back while
+// it waits and taken again for the retry.
+func runLoggedWaiting(ctx context.Context, log *slog.Logger, config Config, container string, cmd *exec.Cmd, release func()) error {
+	outb := &cappedBuffer{limit: config.ShellMaxStdout}
+	errb := &cappedBuffer{limit: config.ShellMaxStderr}
+	cmd.Stdout = outb
+	cmd.Stderr = errb
+	startedAt := time.Now()
+	err := cmd.Run()
+	// The slot is not held while waiting for the container, which can take a while.
+	release()
+	if err == nil || ctx.Err() != nil || !containerNotRunning(errb.String()) {
+		return logOutput(ctx, log, config, startedAt, err, outb, errb)
+	}
+
+	log.Warn("Target container is not running, waiting for it", "target_container", container, "timeout", config.ShellWaitForContainer.String(), "stderr", redact(strings.TrimSpace(errb.String()), config.ShellRedactPatterns))
+	if err := waitForContainerRunning(ctx, container, config.ShellWaitForContainer); err != nil {
+		log.Error("Target container did not become ready", "target_container", container, "error", err)
+		return fmt.Errorf("target container %s: %w", container, err)
+	}
+	log.Info("Target container is running, retrying the command", "target_container", container, "waited", time.Since(startedAt).Round(time.Millisecond).String())
+	release, err = acquireDockerExecSlot(ctx, log)
+	if err != nil {
+		return err
+	}
+	defer release()
+	retry := exec.CommandContext(ctx, cmd.Path, cmd.Args[1:]...)
+	retry.WaitDelay = cmd.WaitDelay
+	return runLogged(ctx, log, config, retry)
+}
+
+// runLogged runs cmd and logs its stdout and stderr, redacted with the job's patterns.
+// If ctx expires first, whatever the command wrote before it was killed is logged
+// together with the timeout.
+func runLogged(ctx context.Context, log *slog.Logger, config Config, cmd *exec.Cmd) error {
+	outb := &cappedBuffer{limit: config.ShellMaxStdout}
+	errb := &cappedBuffer{limit: config.ShellMaxStderr}
+	cmd.Stdout = outb
+	cmd.Stderr = errb
+	startedAt := time.Now()
+	return logOutput(ctx, log, config, startedAt, cmd.Run(), outb, errb)
+}
+
+// runLoggedPTY is runLogged for SHELL_ALLOCATE_PTY: cmd runs attached to a new
+// pseudo-terminal, which merges stderr into stdout.
+func runLoggedPTY(ctx context.Context, log *slog.Logger, config Config, cmd *exec.Cmd) error {
+	outb := &cappedBuffer{limit: config.ShellMaxStdout}
+	startedAt := time.Now()
+	return logOutput(ctx, log, config, startedAt, runInPTY(cmd, outb), outb, &cappedBuffer{})
+}
+
+// logOutput logs the outcome and captured output of a command started at startedAt.
+func logOutput(ctx context.Context, log *slog.Logger, config Config, startedAt time.Time, err error, outb, errb *cappedBuffer) error {
+	stdout := redact(strings.TrimSpace(outb.String()), config.ShellRedactPatterns)
+	stderr := redact(strings.TrimSpace(errb.String()), config.ShellRedactPatterns)
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		elapsed := time.Since(startedAt).Round(time.Millisecond)
+		log.Error("Command timed out, captured partial output", "timed_out", true, "timed_out_after", elapsed.String(), "stdout", stdout, "stderr", stderr, "error", err)
+		return fmt.Errorf("command timed out after %s: %w", elapsed, ctx.Err())
+	}
+
+	if stdout != "" {
+		log.Info("Command stdout", "output", stdout)
+	}
+	if stderr != "" {
+		log.Error("Command stderr", "output", stderr)
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || !exitCodeAccepted(config.ShellSuccessCodes, exitErr.ExitCode()) {
+			log.Error("Shell command failed to execute", "error", err)
+			return err
+		}
+		log.Info("Command exited with an accepted exit code", "exit_code", exitErr.ExitCode())
+	}
+	return checkOutput(log, config, outb.String()+errb.String())
+}
+
+// parseExitCodes parses a SHELL_SUCCESS_CODES list such as "0,2".
+func parseExitCodes(v string) ([]int, error) {
+	var codes []int
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		code, err := strconv.Atoi(part)
+		if err != nil || code < 0 || code > 255 {
+			return nil, fmt.Errorf("malformed exit code %q: expected a number between 0 and 255", part)
+		}
+		codes = append(codes, code)
+	}
+	if len(codes) == 0 {
+		return nil, errors.New("no exit codes given")
+	}
+	return codes, nil
+}
+
+// exitCodeAccepted reports whether a command that exited with code succeeded.
+// Without SHELL_SUCCESS_CODES, only 0 does.
+func exitCodeAccepted(codes []int, code int) bool {
+	if codes == nil {
+		return code == 0
+	}
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOutput fails a command that exited successfully if its combined stdout and
+// stderr match SHELL_FAIL_IF_OUTPUT_MATCHES or don't match SHELL_REQUIRE_OUTPUT_MATCHES.
+func checkOutput(log *slog.Logger, config Config, output string) error {
+	if re := config.ShellFailIfOutput; re != nil && re.MatchString(output) {
+		log.Error("Command output matches the failure pattern", "pattern", re.String(), "match", redact(re.FindString(output), config.ShellRedactPatterns))
+		return fmt.Errorf("output matches SHELL_FAIL_IF_OUTPUT_MATCHES %q", re.String())
+	}
+	if re := config.ShellRequireOutput; re != nil && !re.MatchString(output) {
+		log.Error("Command output does not match the required pattern", "pattern", re.String())
+		return fmt.Errorf("output does not match SHELL_REQUIRE_OUTPUT_MATCHES %q", re.String())
+	}
+	return nil
+}
+
+// cappedBuffer keeps the first limit bytes written to it and counts the rest, so a
+// chatty command can't flood the logs. A limit of 0 keeps everything.
+type cappedBuffer struct {
+	buf     bytes.Buffer
+	limit   int
+	dropped int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.limit <= 0 {
+		return b.buf.Write(p)
+	}
+	room := b.limit - b.buf.Len()
+	if room < 0 {
+		room = 0
+	}
+	if len(p) > room {
+		b.dropped += len(p) - room
+		b.buf.Write(p[:room])
+	} else {
+		b.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// String returns the captured output, followed by a marker if any was truncated.
+func (b *cappedBuffer) String() string {
+	if b.dropped == 0 {
+		return b.buf.String()
+	}
+	return fmt.Sprintf("%s\n... [truncated %d bytes]", b.buf.String(), b.dropped)
+}
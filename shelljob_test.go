@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunShellCommandTimeoutLogsPartialOutput(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	err := runShellCommand(ctx, log, Config{ShellInterpreter: "sh"}, "", "echo partial; echo oops >&2; exec sleep 10")
+	if err == nil {
+		t.Fatal("expected an error for a timed out command")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want it to wrap context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("command took %s to be stopped after its deadline", elapsed)
+	}
+
+	var found bool
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		if rec["msg"] != "Command timed out, captured partial output" {
+			continue
+		}
+		found = true
+		if rec["timed_out"] != true {
+			t.Errorf("timed_out = %v, want true", rec["timed_out"])
+		}
+		if rec["stdout"] != "partial" {
+			t.Errorf("stdout = %q, want %q", rec["stdout"], "partial")
+		}
+		if rec["stderr"] != "oops" {
+			t.Errorf("stderr = %q, want %q", rec["stderr"], "oops")
+		}
+		if rec["timed_out_after"] == "" {
+			t.Error("timed_out_after is empty")
+		}
+	}
+	if !found {
+		t.Fatalf("no timeout log record in:\n%s", buf.String())
+	}
+}
+
+func TestRunShellJob(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+		// wantOutput lists the "phase: stdout" of the commands that ran, in order.
+		wantOutput []string
+	}{
+		{
+			name:       "success",
+			config:     Config{ShellCommand: "echo done"},
+			wantOutput: []string{": done"},
+		},
+		{
+			name:    "non-zero exit",
+			config:  Config{ShellCommand: "exit 3"},
+			wantErr: true,
+		},
+		{
+			name:   "accepted exit code",
+			config: Config{ShellCommand: "exit 3", ShellSuccessCodes: []int{0, 3}},
+		},
+		{
+			name:    "required output missing",
+			config:  Config{ShellCommand: "echo partial", ShellRequireOutput: regexp.MustCompile("^done$")},
+			wantErr: true,
+		},
+		{
+			name:       "output is truncated",
+			config:     Config{ShellCommand: "printf 0123456789", ShellMaxStdout: 4},
+			wantOutput: []string{": 0123\n... [truncated 6 bytes]"},
+		},
+		{
+			name:       "hooks run around the command",
+			config:     Config{ShellCommand: "echo main", ShellPreCommand: "echo pre", ShellPostCommand: "echo post"},
+			wantOutput: []string{"pre: pre", "main: main", "post: post"},
+		},
+		{
+			name:       "failed pre-command skips the command",
+			config:     Config{ShellCommand: "echo main", ShellPreCommand: "echo pre; false", ShellPostCommand: "echo post"},
+			wantErr:    true,
+			wantOutput: []string{"pre: pre", "post: post"},
+		},
+		{
+			name:       "post-command runs after a failure",
+			config:     Config{ShellCommand: "echo main; exit 1", ShellPostCommand: "echo post"},
+			wantErr:    true,
+			wantOutput: []string{"main: main", "post: post"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log := slog.New(slog.NewJSONHandler(&buf, nil))
+			config := tt.config
+			config.ShellInterpreter = "sh"
+			config.ShellTimeout = 10 * time.Second
+
+			err := runShellJob(context.Background(), log, config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error: %v", err, tt.wantErr)
+			}
+
+			var output []string
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				var rec map[string]interface{}
+				if err := json.Unmarshal([]byte(line), &rec); err != nil {
+					t.Fatalf("invalid log line %q: %v", line, err)
+				}
+				if rec["msg"] == "Command stdout" {
+					phase, _ := rec["phase"].(string)
+					output = append(output, fmt.Sprintf("%s: %s", phase, rec["output"]))
+				}
+			}
+			if tt.wantOutput != nil && strings.Join(output, "\n") != strings.Join(tt.wantOutput, "\n") {
+				t.Errorf("output = %q, want %q", output, tt.wantOutput)
+			}
+		})
+	}
+}
+
+func TestParseShellEnv(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []string
+	}{
+		{"PGPASSWORD=secret,API_BASE=https://api", []string{"PGPASSWORD=secret", "API_BASE=https://api"}},
+		{"HOSTS=a,b,c", []string{"HOSTS=a,b,c"}},
+		{"A=1, B=2,c", []string{"A=1", "B=2,c"}},
+		{"A=1;;B=2\nC=x,D=y", []string{"A=1", "B=2", "C=x", "D=y"}},
+		{"", nil},
+	} {
+		if got := parseShellEnv(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseShellEnv(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestShellCommandTemplates(t *testing.T) {
+	tests := []struct {
+		name     string
+		job      map[string]interface{}
+		wantErr  bool
+		rendered string
+	}{
+		{
+			name:     "docker --format runs verbatim",
+			job:      map[string]interface{}{"SHELL_COMMAND": "docker ps --format '{{.Names}}'"},
+			rendered: "docker ps --format '{{.Names}}'",
+		},
+		{
+			name:     "SHELL_TEMPLATE renders placeholders",
+			job:      map[string]interface{}{"SHELL_COMMAND": "echo {{.Name}}", "SHELL_TEMPLATE": "true"},
+			rendered: "echo report",
+		},
+		{
+			name:     "SHELL_TEMPLATE with an escaped brace",
+			job:      map[string]interface{}{"SHELL_COMMAND": `docker ps --format '{{"{{"}}.Names}}' > {{.Name}}.txt`, "SHELL_TEMPLATE": "true"},
+			rendered: "docker ps --format '{{.Names}}' > report.txt",
+		},
+		{
+			name:    "SHELL_TEMPLATE rejects unknown fields",
+			job:     map[string]interface{}{"SHELL_COMMAND": "docker ps --format '{{.Names}}'", "SHELL_TEMPLATE": "true"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := map[string]interface{}{"JOB_NAME": "report", "JOB_TYPE": "shell", "CRON_SCHEDULE": "@daily"}
+			for key, v := range tt.job {
+				job[key] = v
+			}
+			sources, err := newMapJobSources([]map[string]interface{}{job}, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			config, err := parseJob(sources[0], "job", discardLog)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("configuration loaded, want it to be invalid")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("configuration is invalid: %v", err)
+			}
+			if got, err := renderCommand(config, config.ShellCommand); err != nil || got != tt.rendered {
+				t.Errorf("rendered command = %q, %v, want %q", got, err, tt.rendered)
+			}
+		})
+	}
+}
+
+func TestWaitForContainerReleasesDockerExecSlot(t *testing.T) {
+	dir := t.TempDir()
+	started, ready := filepath.Join(dir, "started"), filepath.Join(dir, "ready")
+	// The fake docker fails the first exec as if the container were restarting, and
+	// reports it running once the test created ready.
+	script := `#!/bin/sh
+case "$1" in
+exec)
+	if [ ! -e ` + started + ` ]; then
+		touch ` + started + `
+		echo "Error response from daemon: Container app is restarting" >&2
+		exit 1
+	fi
+	echo done ;;
+inspect)
+	while [ ! -e ` + ready + ` ]; do sleep 0.01; done
+	echo "true false" ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	slots := dockerExecSlots
+	dockerExecSlots = newSemaphore(1)
+	defer func() { dockerExecSlots = slots }()
+
+	config := Config{ShellInterpreter: "sh", ShellWaitForContainer: time.Minute}
+	done := make(chan error, 1)
+	go func() {
+		done <- runShellCommand(context.Background(), discardLog, config, "app", "true")
+	}()
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		if _, err := os.Stat(started); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the first docker exec did not run")
+		}
+	}
+	// The only slot is given back while the job waits for the container.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	release, err := dockerExecSlots.acquire(ctx, discardLog, "docker exec")
+	if err != nil {
+		t.Fatalf("docker exec slot still held while waiting for the container: %v", err)
+	}
+	if err := os.WriteFile(ready, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("retry after the container came back: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not finish after the container came back")
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !sigv4
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// sigV4Supported reports whether this binary was built with the AWS signer; the
+// default build leaves the AWS SDK out.
+const sigV4Supported = false
+
+func signSigV4(ctx context.Context, req *http.Request, body, region, service string) error {
+	return errors.New("AWS SigV4 signing is not available in this build (build with -tags sigv4)")
+}
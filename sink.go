@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// runRecord describes one completed job run. It is the unit shipped to the results sink.
+type runRecord struct {
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	Job           string    `json:"job"`
+	Type          string    `json:"type"`
+	StartedAt     time.Time `json:"started_at"`
+	FinishedAt    time.Time `json:"finished_at"`
+	DurationMs    int64     `json:"duration_ms"`
+	Success       bool      `json:"success"`
+	Error         string    `json:"error,omitempty"`
+	RoutingKey    string    `json:"routing_key,omitempty"`
+	// Slow marks successful runs that exceeded the job's CRON_SLOW_THRESHOLD.
+	Slow bool `json:"slow,omitempty"`
+	// Transient marks a failure of a CRON_TRANSIENT_FAILURES job that directly followed
+	// a success and was therefore downgraded to a warning.
+	Transient bool `json:"transient,omitempty"`
+	// Changed is set for successful runs of change-detection jobs (CRON_CONDITIONAL,
+	// CRON_DETECT_CHANGES): false marks a no-op run that found nothing new.
+	Changed *bool `json:"changed,omitempty"`
+	// StartupGrace marks failures that happened during STARTUP_GRACE and were not alerted on.
+	StartupGrace bool `json:"startup_grace,omitempty"`
+}
+
+// newRunRecord builds the record for a run of config that started at startedAt and
+// finished now with the given error (nil on success).
+func newRunRecord(config Config, startedAt time.Time, err error) runRecord {
+	finishedAt := time.Now()
+	rec := runRecord{
+		Job:        config.Name,
+		Type:       config.JobType,
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+		DurationMs: finishedAt.Sub(startedAt).Milliseconds(),
+		Success:    err == nil,
+		RoutingKey: config.RoutingKey,
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	return rec
+}
+
+// resultsSink buffers run records and POSTs them as JSON arrays to an HTTP endpoint,
+// either every flush interval or as soon as a full batch is buffered. Records from
+// failed deliveries stay buffered for the next attempt; once the buffer is full the
+// oldest records are dropped. A nil *resultsSink is valid and discards everything.
+type resultsSink struct {
+	url         string
+	interval    time.Duration
+	batchSize   int
+	maxBuffered int
+	client      *http.Client
+	logger      *slog.Logger
+
+	mu      sync.Mutex
+	pending []runRecord
+	dropped int
+
+	flushNow chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// newResultsSinkFromEnv builds a results sink from RESULTS_SINK_URL and friends.
+// It returns nil when no sink URL is configured.
+func newResultsSinkFromEnv(logger *slog.Logger) *resultsSink {
+	url := os.Getenv("RESULTS_SINK_URL")
+	if url == "" {
+		return nil
+	}
+
+	s := &resultsSink{
+		url:         url,
+		interval:    envDuration(logger, "RESULTS_FLUSH_INTERVAL", 30*time.Second),
+		batchSize:   envInt(logger, "RESULTS_BATCH_SIZE", 100),
+		maxBuffered: envInt(logger, "RESULTS_BUFFER_SIZE", 1000),
+		client:      &http.Client{Timeout: 10 * time.Second},
+		logger:      logger.With("component", "results_sink"),
+		flushNow:    make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if s.interval <= 0 {
+		s.interval = 30 * time.Second
+	}
+	if s.batchSize <= 0 {
+		s.batchSize = 100
+	}
+	if s.maxBuffered < s.batchSize {
+		s.maxBuffered = s.batchSize
+	}
+	s.logger.Info("Results sink enabled", "url", redactURL(url), "flush_interval", s.interval.String(), "batch_size", s.batchSize, "buffer_size", s.maxBuffered)
+	return s
+}
+
+// Record queues a run record for delivery.
+func (s *resultsSink) Record(rec runRecord) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.pending = append(s.pending, rec)
+	s.trimLocked()
+	full := len(s.pending) >= s.batchSize
+	s.mu.Unlock()
+
+	if full {
+		select {
+		case s.flushNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// trimLocked drops the oldest records once the buffer exceeds its capacity.
+func (s *resultsSink) trimLocked() {
+	if over := len(s.pending) - s.maxBuffered; over > 0 {
+		s.pending = append([]runRecord(nil), s.pending[over:]...)
+		s.dropped += over
+		s.logger.Warn("Results sink buffer full, dropped oldest records", "dropped", over, "dropped_total", s.dropped)
+	}
+}
+
+// Start runs the background flush loop.
+func (s *resultsSink) Start() {
+	if s == nil {
+		return
+	}
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.flush()
+			case <-s.flushNow:
+				s.flush()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the flush loop and makes a final attempt to deliver buffered records.
+func (s *resultsSink) Close() {
+	if s == nil {
+		return
+	}
+
+	close(s.stop)
+	<-s.done
+	s.flush()
+
+	s.mu.Lock()
+	remaining := len(s.pending)
+	s.mu.Unlock()
+	if remaining > 0 {
+		s.logger.Error("Results sink could not deliver all records before shutdown", "undelivered", remaining)
+	}
+}
+
+// flush delivers buffered records in batches until the buffer is empty or a delivery fails.
+func (s *resultsSink) flush() {
+	for {
+		s.mu.Lock()
+		n := len(s.pending)
+		if n == 0 {
+			s.mu.Unlock()
+			return
+		}
+		if n > s.batchSize {
+			n = s.batchSize
+		}
+		batch := s.pending[:n:n]
+		s.pending = s.pending[n:]
+		s.mu.Unlock()
+
+		if err := s.post(batch); err != nil {
+			s.logger.Error("Failed to deliver results batch, will retry", "records", len(batch), "error", err)
+			// Put the batch back in front so ordering is preserved on the next attempt.
+			s.mu.Lock()
+			s.pending = append(batch, s.pending...)
+			s.trimLocked()
+			s.mu.Unlock()
+			return
+		}
+		s.logger.Info("Delivered results batch", "records", len(batch))
+	}
+}
+
+func (s *resultsSink) post(batch []runRecord) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return redactURLError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sink responded with status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// missedRunsLimit caps how many missed runs are counted for a job, so a job that runs
+// every second and was down for a month doesn't hold up the startup.
+const missedRunsLimit = 1000
+
+// jobRunState is what STATE_FILE remembers of a job between restarts.
+type jobRunState struct {
+	// LastRun is when the job's latest run finished, successful or not.
+	LastRun     time.Time  `json:"last_run"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	// LastStarted is when that run started.
+	LastStarted time.Time `json:"last_started,omitempty"`
+}
+
+// runState keeps the time of each job's latest run in STATE_FILE, so runs missed while
+// the runner was down can be detected after a restart. A nil runState records nothing.
+type runState struct {
+	path   string
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	entries map[string]jobRunState
+}
+
+// newRunStateFromEnv returns nil when STATE_FILE is not set. A state file that can't
+// be read is logged and replaced.
+func newRunStateFromEnv(logger *slog.Logger) *runState {
+	path := os.Getenv("STATE_FILE")
+	if path == "" {
+		return nil
+	}
+	state := &runState{path: path, logger: logger, entries: make(map[string]jobRunState)}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		logger.Error("Failed to read state file, starting empty", "path", path, "error", err)
+	default:
+		if err := json.Unmarshal(data, &state.entries); err != nil {
+			logger.Error("Failed to parse state file, starting empty", "path", path, "error", err)
+			state.entries = make(map[string]jobRunState)
+		}
+	}
+	logger.Info("Run state file enabled", "path", path, "known_jobs", len(state.entries))
+	return state
+}
+
+// record remembers rec as the latest run of its job and persists the state.
+func (s *runState) record(rec runRecord) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := s.entries[rec.Job]
+	entry.LastRun = rec.FinishedAt
+	entry.LastStarted = rec.StartedAt
+	if rec.Success {
+		finishedAt := rec.FinishedAt
+		entry.LastSuccess = &finishedAt
+	}
+	s.entries[rec.Job] = entry
+	if err := writeFileAtomic(s.path, s.entries); err != nil {
+		s.logger.Error("Failed to persist state file", "path", s.path, "error", err)
+	}
+}
+
+// last returns when the latest recorded run of job started and whether it succeeded.
+// State files written before the start was recorded give the time the run finished.
+func (s *runState) last(job string) (startedAt time.Time, success, ok bool) {
+	if s == nil {
+		return time.Time{}, false, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[job]
+	if !ok {
+		return time.Time{}, false, false
+	}
+	startedAt = entry.LastStarted
+	if startedAt.IsZero() {
+		startedAt = entry.LastRun
+	}
+	success = entry.LastSuccess != nil && entry.LastSuccess.Equal(entry.LastRun)
+	return startedAt, success, true
+}
+
+// missed reports the runs of job that schedule should have started between the job's
+// last recorded run and now: the time of the first one and how many there were, up
+// to missedRunsLimit. Jobs without a recorded run have missed nothing.
+func (s *runState) missed(job string, schedule cron.Schedule, now time.Time) (entry jobRunState, first time.Time, count int) {
+	if s == nil {
+		return entry, first, 0
+	}
+	s.mu.Lock()
+	entry, ok := s.entries[job]
+	s.mu.Unlock()
+	if !ok {
+		return entry, first, 0
+	}
+	for next := schedule.Next(entry.LastRun); !next.IsZero() && next.Before(now) && count < missedRunsLimit; next = schedule.Next(next) {
+		if count == 0 {
+			first = next
+		}
+		count++
+	}
+	return entry, first, count
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateData is what the placeholders of CRON_TARGET_URL and, with SHELL_TEMPLATE,
+// SHELL_COMMAND can refer to, e.g. {{.Now.Format "2006-01-02"}} or {{.Name}}.
+type templateData struct {
+	// Now is when the run renders the template, in the job's CRON_TZ.
+	Now time.Time
+	// Name is the job's name.
+	Name string
+	// LastRun is the job's latest completed run.
+	LastRun previousRun
+}
+
+// previousRun is the {{.LastRun}} of a template: when the job's latest completed run
+// started, e.g. {{.LastRun.Format "2006-01-02T15:04:05Z07:00"}}, and its Status.
+type previousRun struct {
+	time.Time
+	// Status is "success" or "failure", or "" if the job hasn't run yet; the time is
+	// then templateEpoch.
+	Status string
+}
+
+// templateEpoch is TEMPLATE_EPOCH, the {{.LastRun}} of a job that hasn't run yet.
+var templateEpoch = time.Unix(0, 0).UTC()
+
+// lastRunOf returns when the latest completed run of a job started and whether it
+// succeeded. main sets it up from the run statistics and STATE_FILE; until then every
+// job counts as never run.
+var lastRunOf = func(job string) (startedAt time.Time, success, ok bool) {
+	return time.Time{}, false, false
+}
+
+// lastRun returns the {{.LastRun}} of the job name, in the time zone of now.
+func lastRun(name string, now time.Time) previousRun {
+	startedAt, success, ok := lastRunOf(name)
+	if !ok {
+		return previousRun{Time: templateEpoch.In(now.Location())}
+	}
+	run := previousRun{Time: startedAt.In(now.Location()), Status: "failure"}
+	if success {
+		run.Status = "success"
+	}
+	return run
+}
+
+// renderTemplate renders the placeholders in s for a run of the job name at now.
+// Strings without placeholders are returned as they are.
+func renderTemplate(s string, now time.Time, name string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	tmpl, err := template.New("").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, templateData{Now: now, Name: name, LastRun: lastRun(name, now)}); err != nil {
+		return "", fmt.Errorf("failed to render template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// renderCommand renders the placeholders in a shell command of config if the job
+// sets SHELL_TEMPLATE, and returns it as it is otherwise.
+func renderCommand(config Config, command string) (string, error) {
+	if !config.ShellTemplate {
+		return command, nil
+	}
+	return renderTemplate(command, jobNow(config), config.Name)
+}
+
+// jobNow is the current time in the job's CRON_TZ, or the local time zone without one.
+func jobNow(config Config) time.Time {
+	now := time.Now()
+	if config.Timezone == "" {
+		return now
+	}
+	if loc, err := time.LoadLocation(config.Timezone); err == nil {
+		return now.In(loc)
+	}
+	return now
+}